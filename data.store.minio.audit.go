@@ -0,0 +1,348 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// AuditRecord describes a change of the store, e.g. a Set, Copy, Delete,
+// DeleteBatch or Reset. Result is one of the METRICS_RESULT_* values.
+type AuditRecord struct {
+	Time         time.Time `json:"time"`
+	Operation    string    `json:"operation"`
+	Store        string    `json:"store"`
+	Actor        string    `json:"actor,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Bucket       string    `json:"bucket,omitempty"`
+	Key          string    `json:"key,omitempty"`
+	SourceBucket string    `json:"sourceBucket,omitempty"`
+	SourceKey    string    `json:"sourceKey,omitempty"`
+	// SourceVersionID is the version restored by RestoreVersion.
+	SourceVersionID string `json:"sourceVersionId,omitempty"`
+	// Size is the size of the object as stored, i.e. encrypted if a crypto
+	// service is used.
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"etag,omitempty"`
+	VersionID string `json:"versionId,omitempty"`
+	// Objects is the number of objects removed by Reset, DeleteBatch or
+	// DeletePrefix.
+	Objects int64 `json:"objects,omitempty"`
+	// Keys are the objects removed by DeleteBatch or DeletePrefix, a record
+	// is written per bucket.
+	Keys   []string `json:"keys,omitempty"`
+	Result string   `json:"result"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// AuditSink receives the audit records of a store.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, record *AuditRecord) error
+}
+
+// AuditSinkFunc is a function used as AuditSink.
+type AuditSinkFunc func(ctx context.Context, record *AuditRecord) error
+
+func (f AuditSinkFunc) WriteAudit(ctx context.Context, record *AuditRecord) error {
+	return f(ctx, record)
+}
+
+// AuditIdentityFunc returns the actor and the tenant of an operation from
+// its context.
+type AuditIdentityFunc func(ctx context.Context) (actor, tenant string)
+
+// DataStoreOptionWithAuditSinks writes an audit record of every change of the
+// store to the sinks: Set, Copy, Delete, Reset, DeleteBatch, DeletePrefix,
+// Move, DeleteVersion, RestoreVersion and CopyAcross into the store. Records are written before the operation
+// returns, a failed sink is logged and does not fail the operation.
+func DataStoreOptionWithAuditSinks(sinks ...AuditSink) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_AUDIT_SINKS, sinks)
+}
+
+// DataStoreOptionWithAuditIdentity sets how actor and tenant are taken from
+// the context of an operation. By default they are the values set with
+// ContextWithAuditActor and ContextWithAuditTenant.
+func DataStoreOptionWithAuditIdentity(identity AuditIdentityFunc) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_AUDIT_IDENTITY, identity)
+}
+
+// auditActorKey and auditTenantKey are the context keys of actor and tenant.
+type auditActorKey struct{}
+type auditTenantKey struct{}
+
+// ContextWithAuditActor returns a context whose operations are audited as
+// done by actor.
+func ContextWithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// ContextWithAuditTenant returns a context whose operations are audited as
+// done for tenant.
+func ContextWithAuditTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, auditTenantKey{}, tenant)
+}
+
+func auditIdentityFromContext(ctx context.Context) (actor, tenant string) {
+	actor, _ = ctx.Value(auditActorKey{}).(string)
+	tenant, _ = ctx.Value(auditTenantKey{}).(string)
+	return actor, tenant
+}
+
+// newAuditRecord starts the audit record of an operation.
+func (dsm *dataStoreMinio) newAuditRecord(ctx context.Context, operation, bucketName, objectName string) *AuditRecord {
+	identity := auditIdentityFromContext
+	if fn, ok := attributeValue[AuditIdentityFunc](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_AUDIT_IDENTITY); ok && fn != nil {
+		identity = fn
+	}
+	actor, tenant := identity(ctx)
+	return &AuditRecord{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		Store:     dsm.String(),
+		Actor:     actor,
+		Tenant:    tenant,
+		Bucket:    bucketName,
+		Key:       objectName,
+	}
+}
+
+// audit completes the record with the outcome of the operation and writes
+// it to the configured sinks.
+func (dsm *dataStoreMinio) audit(ctx context.Context, record *AuditRecord, err error) {
+	sinks, _ := attributeValue[[]AuditSink](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_AUDIT_SINKS)
+	if len(sinks) == 0 {
+		return
+	}
+	record.Result = operationResult(err)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	// the operation is done, its cancellation must not lose the record
+	ctx = context.WithoutCancel(ctx)
+	for _, sink := range sinks {
+		if sinkErr := sink.WriteAudit(ctx, record); sinkErr != nil {
+			dsm.logger().Error("failed to write audit record", "store", dsm.String(), "operation", record.Operation,
+				"bucket", record.Bucket, "object", record.Key, "error", sinkErr)
+		}
+	}
+}
+
+// auditSinkLogger writes audit records as log entries.
+type auditSinkLogger struct {
+	logger *slog.Logger
+}
+
+// NewAuditSinkLogger creates a sink writing audit records to logger at info
+// level, slog.Default() if logger is nil.
+func NewAuditSinkLogger(logger *slog.Logger) AuditSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &auditSinkLogger{logger: logger}
+}
+
+func (s *auditSinkLogger) WriteAudit(ctx context.Context, record *AuditRecord) error {
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "audit",
+		slog.Time("timestamp", record.Time),
+		slog.String("operation", record.Operation),
+		slog.String("store", record.Store),
+		slog.String("actor", record.Actor),
+		slog.String("tenant", record.Tenant),
+		slog.String("bucket", record.Bucket),
+		slog.String("object", record.Key),
+		slog.String("sourceBucket", record.SourceBucket),
+		slog.String("sourceObject", record.SourceKey),
+		slog.String("sourceVersionId", record.SourceVersionID),
+		slog.Int64("size", record.Size),
+		slog.String("etag", record.ETag),
+		slog.String("versionId", record.VersionID),
+		slog.Int64("objects", record.Objects),
+		slog.Any("objectNames", record.Keys),
+		slog.String("result", record.Result),
+		slog.String("error", record.Error),
+	)
+	return nil
+}
+
+// AuditSinkFile appends audit records as JSON lines to a file.
+type AuditSinkFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewAuditSinkFile opens the file for appending audit records, creating it
+// if needed. The sink must be closed when it is no longer used.
+func NewAuditSinkFile(path string) (*AuditSinkFile, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return &AuditSinkFile{file: file}, nil
+}
+
+func (s *AuditSinkFile) WriteAudit(ctx context.Context, record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (s *AuditSinkFile) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// auditSinkBucket writes every audit record as a new object to a bucket.
+type auditSinkBucket struct {
+	dsm        *dataStoreMinio
+	bucketName string
+	// default retention of the records
+	retentionMode minio.RetentionMode
+	retentionDays uint
+	// set once the bucket is known to exist with object locking
+	ready atomic.Bool
+}
+
+// NewAuditSinkBucket creates a sink writing every audit record as a new JSON
+// object to a bucket of the store. Objects are named by date and time, e.g.
+// "2024/05/01/20240501T120000.000000000Z-1a2b3c4d.json", and never
+// overwritten. Writes to the bucket are not audited themselves.
+// The bucket is created with object locking and a default retention of
+// retentionDays in retentionMode (RETENTION_MODE_GOVERNANCE or
+// RETENTION_MODE_COMPLIANCE), an existing bucket without object locking is
+// rejected. Reset and DeletePrefix of the store skip the bucket.
+func NewAuditSinkBucket(dataStore comby.DataStore, bucketName, retentionMode string, retentionDays uint) (AuditSink, error) {
+	dsm, ok := dataStore.(*dataStoreMinio)
+	if !ok {
+		return nil, fmt.Errorf("audit store '%s' is not a minio data store", dataStore.String())
+	}
+	if bucketName == "" {
+		return nil, fmt.Errorf("audit bucket name is empty")
+	}
+	if retentionMode != RETENTION_MODE_GOVERNANCE && retentionMode != RETENTION_MODE_COMPLIANCE {
+		return nil, fmt.Errorf("invalid audit retention mode %q", retentionMode)
+	}
+	if retentionDays == 0 {
+		return nil, fmt.Errorf("audit retention must be at least one day")
+	}
+	dsm.auditBuckets.Store(bucketName, true)
+	return &auditSinkBucket{
+		dsm:           dsm,
+		bucketName:    bucketName,
+		retentionMode: minio.RetentionMode(retentionMode),
+		retentionDays: retentionDays,
+	}, nil
+}
+
+func (s *auditSinkBucket) WriteAudit(ctx context.Context, record *AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err = rand.Read(suffix); err != nil {
+		return err
+	}
+	objectName := record.Time.UTC().Format("2006/01/02/20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".json"
+	err = s.put(ctx, objectName, data)
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+		// removed in the meantime, e.g. by another client
+		s.ready.Store(false)
+		err = s.put(ctx, objectName, data)
+	}
+	if err != nil {
+		return fmt.Errorf("PutObject(%s/%s): %w", s.bucketName, objectName, err)
+	}
+	return nil
+}
+
+// put writes a record object, unless an object of this name exists.
+func (s *auditSinkBucket) put(ctx context.Context, objectName string, data []byte) error {
+	if !s.ready.Load() {
+		if err := s.ensureBucket(ctx); err != nil {
+			return err
+		}
+		s.ready.Store(true)
+	}
+	ctx = withRequestHeader(ctx, http.Header{"If-None-Match": []string{"*"}})
+	_, err := s.dsm.minioClient.PutObject(ctx, s.bucketName, objectName, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	return err
+}
+
+// ensureBucket creates the bucket with object locking if needed and sets
+// its default retention. The policy, versioning and lifecycle options of the
+// store are not applied, the records must not expire before their retention.
+func (s *auditSinkBucket) ensureBucket(ctx context.Context) error {
+	exists, err := s.dsm.minioClient.BucketExists(ctx, s.bucketName)
+	if err != nil {
+		exists = false
+	}
+	if !exists {
+		if err = s.dsm.minioClient.MakeBucket(ctx, s.bucketName, minio.MakeBucketOptions{
+			Region:        s.dsm.options.BucketRegion,
+			ObjectLocking: true,
+		}); err != nil {
+			return err
+		}
+		s.dsm.metrics().bucketCreated(s.dsm)
+		s.dsm.logger().Info("bucket created", "store", s.dsm.String(), "bucket", s.bucketName,
+			"region", s.dsm.options.BucketRegion, "objectLocking", true)
+	} else {
+		enabled, _, _, _, err := s.dsm.minioClient.GetObjectLockConfig(ctx, s.bucketName)
+		if err != nil {
+			return fmt.Errorf("GetObjectLockConfig(%s): %w", s.bucketName, err)
+		}
+		if enabled != "Enabled" {
+			return fmt.Errorf("audit bucket %s has no object locking", s.bucketName)
+		}
+	}
+	unit := minio.Days
+	return s.dsm.minioClient.SetObjectLockConfig(ctx, s.bucketName, &s.retentionMode, &s.retentionDays, &unit)
+}
+
+// isAuditBucket reports whether the bucket holds the records of an audit
+// sink writing to this store or to the same endpoint.
+func (dsm *dataStoreMinio) isAuditBucket(bucketName string) bool {
+	if _, ok := dsm.auditBuckets.Load(bucketName); ok {
+		return true
+	}
+	sinks, _ := attributeValue[[]AuditSink](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_AUDIT_SINKS)
+	for _, sink := range sinks {
+		if sink, ok := sink.(*auditSinkBucket); ok && sink.bucketName == bucketName && sink.dsm.Endpoint == dsm.Endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutAuditBuckets returns the names of the buckets, except the audit
+// buckets.
+func (dsm *dataStoreMinio) withoutAuditBuckets(buckets []minio.BucketInfo) []string {
+	bucketNames := make([]string, 0, len(buckets))
+	for _, bucket := range buckets {
+		if dsm.isAuditBucket(bucket.Name) {
+			continue
+		}
+		bucketNames = append(bucketNames, bucket.Name)
+	}
+	return bucketNames
+}
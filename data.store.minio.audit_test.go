@@ -0,0 +1,251 @@
+package store_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreAudit(t *testing.T) {
+	var err error
+	ctx := store.ContextWithAuditTenant(store.ContextWithAuditActor(context.Background(), "alice"), "tenant-1")
+	fake, endpoint := newFakeS3(t)
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	fileSink, err := store.NewAuditSinkFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileSink.Close()
+	output := &syncBuffer{}
+
+	// setup and init store writing audit records to a file, a logger and a
+	// bucket of the store itself
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithResetAllowlist("minio.dev.example.com"),
+	)
+	if _, err = store.NewAuditSinkBucket(dataStore, "audit", "FOREVER", 30); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = store.NewAuditSinkBucket(dataStore, "audit", store.RETENTION_MODE_GOVERNANCE, 0); err == nil {
+		t.Fatal("expected error")
+	}
+	bucketSink, err := store.NewAuditSinkBucket(dataStore, "audit", store.RETENTION_MODE_GOVERNANCE, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dataStore.Init(ctx, store.DataStoreOptionWithAuditSinks(
+		fileSink,
+		store.NewAuditSinkLogger(slog.New(slog.NewJSONHandler(output, nil))),
+		bucketSink,
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	// mutating operations are audited, reads are not
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("audit-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("audit-bucket"),
+		comby.DataStoreGetOptionWithObjectName("a.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	// the fake does not copy server-side, failed operations are audited too
+	if err = dataStore.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("audit-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("a.txt"),
+		comby.DataStoreCopyOptionWithDstBucketName("audit-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("b.txt"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+	if err = dataStore.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("audit-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("a.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err := dataStore.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditObjects := 0
+	for _, item := range items {
+		if item.BucketName == "audit" {
+			auditObjects++
+		}
+	}
+	if auditObjects != 3 {
+		t.Fatalf("expected 3 audit objects, got %d", auditObjects)
+	}
+
+	// the audit bucket is created with object locking and a default retention
+	if lock := string(fake.lock("audit")); !strings.Contains(lock, "<Mode>GOVERNANCE</Mode>") || !strings.Contains(lock, "<Days>30</Days>") {
+		t.Fatalf("wrong object lock configuration %s", lock)
+	}
+	// an existing bucket without object locking is rejected
+	otherStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err = otherStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	unlockedSink, err := store.NewAuditSinkBucket(otherStore, "audit-bucket", store.RETENTION_MODE_GOVERNANCE, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = unlockedSink.WriteAudit(ctx, &store.AuditRecord{}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// the audit bucket is not deleted by prefix
+	if _, err = dataStore.DeletePrefix(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("audit"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// batch deletes are audited per bucket with the removed objects
+	for _, objectName := range []string{"b.txt", "c/1.txt", "c/2.txt"} {
+		if err = dataStore.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("audit-bucket"),
+			comby.DataStoreSetOptionWithObjectName(objectName),
+			comby.DataStoreSetOptionWithData([]byte("beta")),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = dataStore.DeleteBatch(ctx, []*comby.DataModel{{BucketName: "audit-bucket", ObjectName: "b.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dataStore.DeletePrefix(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("audit-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("c/"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// copies from another store are audited by the destination store
+	_, srcEndpoint := newFakeS3(t)
+	srcStore := store.NewDataStoreMinio(srcEndpoint, false, "ROOTNAME", "CHANGEME123")
+	if err = srcStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = srcStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("src-bucket"),
+		comby.DataStoreSetOptionWithObjectName("x.txt"),
+		comby.DataStoreSetOptionWithData([]byte("gamma")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.CopyAcross(ctx, srcStore, dataStore,
+		comby.DataStoreCopyOptionWithSrcBucketName("src-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("x.txt"),
+		comby.DataStoreCopyOptionWithDstBucketName("audit-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("x.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// refused resets are audited as well
+	if err = dataStore.Reset(ctx); !errors.Is(err, store.ErrResetNotAllowed) {
+		t.Fatalf("expected ErrResetNotAllowed, got %v", err)
+	}
+
+	// the file has a line per operation
+	file, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []*store.AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &store.AuditRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	operations := []string{"Set", "Copy", "Delete", "Set", "Set", "Set", "DeleteBatch", "DeletePrefix", "CopyAcross", "Reset"}
+	if len(records) != len(operations) {
+		t.Fatalf("expected %d records, got %d", len(operations), len(records))
+	}
+	for i, operation := range operations {
+		record := records[i]
+		if record.Operation != operation || record.Actor != "alice" || record.Tenant != "tenant-1" ||
+			record.Store != "minio://"+endpoint || record.Time.IsZero() {
+			t.Fatalf("wrong record %+v", record)
+		}
+	}
+	if set := records[0]; set.Bucket != "audit-bucket" || set.Key != "a.txt" || set.Size != 5 || set.ETag == "" || set.Result != store.METRICS_RESULT_SUCCESS {
+		t.Fatalf("wrong set record %+v", set)
+	}
+	if copied := records[1]; copied.SourceBucket != "audit-bucket" || copied.SourceKey != "a.txt" || copied.Key != "b.txt" ||
+		copied.Result != store.METRICS_RESULT_ERROR || copied.Error == "" {
+		t.Fatalf("wrong copy record %+v", copied)
+	}
+	if batch := records[6]; batch.Bucket != "audit-bucket" || batch.Objects != 1 || len(batch.Keys) != 1 || batch.Keys[0] != "b.txt" ||
+		batch.Result != store.METRICS_RESULT_SUCCESS {
+		t.Fatalf("wrong delete batch record %+v", batch)
+	}
+	if prefix := records[7]; prefix.Key != "c/" || prefix.Objects != 2 || len(prefix.Keys) != 2 {
+		t.Fatalf("wrong delete prefix record %+v", prefix)
+	}
+	if across := records[8]; across.SourceBucket != "src-bucket" || across.SourceKey != "x.txt" || across.Key != "x.txt" ||
+		across.Size != 5 || across.Result != store.METRICS_RESULT_SUCCESS {
+		t.Fatalf("wrong copy across record %+v", across)
+	}
+	if reset := records[9]; reset.Result != store.METRICS_RESULT_ERROR || reset.Error == "" {
+		t.Fatalf("wrong reset record %+v", reset)
+	}
+
+	// the logger has an entry per operation
+	if entries := strings.Count(output.String(), `"msg":"audit"`); entries != len(operations) {
+		t.Fatalf("expected %d log entries, got %d", len(operations), entries)
+	}
+
+	// every record is an object of the audit bucket
+	items, _, err = dataStore.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lastName string
+	auditObjects = 0
+	for _, item := range items {
+		if item.BucketName == "audit" {
+			auditObjects++
+			lastName = max(lastName, item.ObjectName)
+		}
+	}
+	if auditObjects != len(operations) {
+		t.Fatalf("expected %d audit objects, got %d", len(operations), auditObjects)
+	}
+	model, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("audit"),
+		comby.DataStoreGetOptionWithObjectName(lastName),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &store.AuditRecord{}
+	if err = json.Unmarshal(model.Data, record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Operation != "Reset" || record.Actor != "alice" {
+		t.Fatalf("wrong record in bucket %+v", record)
+	}
+}
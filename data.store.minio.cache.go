@@ -0,0 +1,222 @@
+package store
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gradientzero/comby/v2"
+)
+
+// DataStoreOptionWithCache enables a read-through cache for Get holding up
+// to maxBytes of objects in memory. Cached objects are validated by ETag on
+// every Get, so only unchanged objects are served from the cache. If dir is
+// not empty, objects are also kept in files in this directory, which
+// survive restarts and are not limited in size.
+func DataStoreOptionWithCache(maxBytes int64, dir string) comby.DataStoreOption {
+	return func(opt *comby.DataStoreOptions) (*comby.DataStoreOptions, error) {
+		if _, err := comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_CACHE_MAX_BYTES, maxBytes)(opt); err != nil {
+			return nil, err
+		}
+		return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_CACHE_DIR, dir)(opt)
+	}
+}
+
+// DataStoreOptionWithCacheDecrypted caches decrypted objects, which saves
+// decrypting on every Get. By default objects are cached as stored, i.e.
+// encrypted if a crypto service is used.
+func DataStoreOptionWithCacheDecrypted(decrypted bool) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_CACHE_DECRYPTED, decrypted)
+}
+
+// cacheEntry is a cached object.
+type cacheEntry struct {
+	key  string
+	etag string
+	data []byte
+	// decrypted is set if data was decrypted before caching
+	decrypted bool
+}
+
+// objectCache is a LRU cache of objects with a byte budget, optionally
+// backed by a directory.
+type objectCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	dir      string
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+func newObjectCache(maxBytes int64, dir string) *objectCache {
+	return &objectCache{
+		maxBytes: maxBytes,
+		dir:      dir,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// newCache returns the cache configured in the attributes, nil if caching is
+// disabled.
+func newCache(attributes *comby.Attributes) (*objectCache, error) {
+	maxBytes, _ := attributeValue[int64](attributes, DATA_STORE_ATTRIBUTE_CACHE_MAX_BYTES)
+	dir, _ := attributeValue[string](attributes, DATA_STORE_ATTRIBUTE_CACHE_DIR)
+	if maxBytes <= 0 && dir == "" {
+		return nil, nil
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	return newObjectCache(maxBytes, dir), nil
+}
+
+func cacheKey(bucketName, objectName string) string {
+	return bucketName + "/" + objectName
+}
+
+// get returns the cached entry or nil. Entries only found on disk are moved
+// into memory.
+func (c *objectCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cacheEntry)
+	}
+	c.mu.Unlock()
+
+	entry := c.readFile(key)
+	if entry != nil {
+		c.putMemory(entry)
+	}
+	return entry
+}
+
+// put caches the entry in memory and on disk.
+func (c *objectCache) put(entry *cacheEntry) {
+	c.putMemory(entry)
+	c.writeFile(entry)
+}
+
+func (c *objectCache) putMemory(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(entry.key)
+	size := int64(len(entry.data))
+	if size > c.maxBytes {
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += size
+	for c.size > c.maxBytes {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate removes an object from the cache.
+func (c *objectCache) invalidate(key string) {
+	c.mu.Lock()
+	c.removeLocked(key)
+	c.mu.Unlock()
+	if c.dir != "" {
+		os.Remove(c.path(key))
+	}
+}
+
+// invalidatePrefix removes all objects whose key starts with prefix from
+// memory. Files are kept, they are validated by ETag when read.
+func (c *objectCache) invalidatePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.removeLocked(key)
+		}
+	}
+}
+
+// clear removes all objects from the cache.
+func (c *objectCache) clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
+	c.mu.Unlock()
+	if c.dir != "" {
+		files, _ := filepath.Glob(filepath.Join(c.dir, "*.cache"))
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+}
+
+func (c *objectCache) removeLocked(key string) {
+	if elem, ok := c.entries[key]; ok {
+		c.size -= int64(len(elem.Value.(*cacheEntry).data))
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+func (c *objectCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".cache")
+}
+
+// file layout: key, ETag and decrypted flag on one line each, then the data
+func (c *objectCache) readFile(key string) *cacheEntry {
+	if c.dir == "" {
+		return nil
+	}
+	content, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil
+	}
+	header := bytes.SplitN(content, []byte("\n"), 4)
+	if len(header) != 4 || string(header[0]) != key {
+		return nil
+	}
+	return &cacheEntry{
+		key:       key,
+		etag:      string(header[1]),
+		decrypted: string(header[2]) == "1",
+		data:      header[3],
+	}
+}
+
+func (c *objectCache) writeFile(entry *cacheEntry) {
+	if c.dir == "" {
+		return
+	}
+	decrypted := "0"
+	if entry.decrypted {
+		decrypted = "1"
+	}
+	file, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = file.WriteString(entry.key + "\n" + entry.etag + "\n" + decrypted + "\n")
+	if err == nil {
+		_, err = file.Write(entry.data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path(entry.key))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+}
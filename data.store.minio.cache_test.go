@@ -0,0 +1,116 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreCache(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	fake, endpoint := newFakeS3(t)
+
+	// setup and init store with cache
+	cryptoService, _ := comby.NewCryptoService([]byte("12345678901234567890123456789012"))
+	newStore := func() comby.DataStore {
+		dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+			comby.DataStoreOptionWithCryptoService(cryptoService),
+			store.DataStoreOptionWithCache(1024, cacheDir),
+		)
+		if err := dataStore.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return dataStore
+	}
+	dataStore := newStore()
+
+	set := func(data string) {
+		if err := dataStore.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("cache-bucket"),
+			comby.DataStoreSetOptionWithObjectName("template"),
+			comby.DataStoreSetOptionWithData([]byte(data)),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func() (*comby.DataModel, error) {
+		return dataStore.Get(ctx,
+			comby.DataStoreGetOptionWithBucketName("cache-bucket"),
+			comby.DataStoreGetOptionWithObjectName("template"),
+		)
+	}
+	// every Get revalidates, a cache hit is answered without data
+	expectReads := func(gets, notModified int64) {
+		t.Helper()
+		if got := fake.gets.Load(); got != gets {
+			t.Fatalf("expected %d reads, got %d", gets, got)
+		}
+		if got := fake.notModified.Load(); got != notModified {
+			t.Fatalf("expected %d not modified reads, got %d", notModified, got)
+		}
+	}
+
+	// the first Get fills the cache, the second is served from it
+	set("v1")
+	for i := 0; i < 2; i++ {
+		model, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(model.Data) != "v1" {
+			t.Fatalf("wrong data: %q", model.Data)
+		}
+		// modifying the result does not modify the cache
+		model.Data[0] = 'x'
+	}
+	expectReads(2, 1)
+	files, _ := os.ReadDir(cacheDir)
+	if len(files) != 1 {
+		t.Fatalf("expected one cache file, got %d", len(files))
+	}
+
+	// the cache directory survives a restart
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dataStore = newStore()
+	model, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != "v1" {
+		t.Fatalf("wrong data: %q", model.Data)
+	}
+	expectReads(3, 2)
+
+	// changed objects are fetched again
+	set("v2")
+	if model, err = get(); err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != "v2" {
+		t.Fatalf("wrong data: %q", model.Data)
+	}
+	expectReads(4, 2)
+
+	// removed objects are not served from the cache
+	if err := dataStore.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("cache-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("template"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(); err == nil {
+		t.Fatal("expected error for removed object")
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
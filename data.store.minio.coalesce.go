@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gradientzero/comby/v2"
+)
+
+// DataStoreOptionWithGetCoalescing lets concurrent Gets of the same object
+// version share a single download. A Get joining a download in flight may
+// miss a write completed after that download started.
+func DataStoreOptionWithGetCoalescing(enabled bool) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_GET_COALESCING, enabled)
+}
+
+// flight is a download shared by all callers of the same key.
+type flight struct {
+	done    chan struct{}
+	result  *comby.DataModel
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup deduplicates concurrent calls with the same key.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do calls fn once for all concurrent callers of key. fn runs detached from
+// the context of the callers and is only cancelled when all of them gave
+// up. Every caller gets its own copy of the result.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (*comby.DataModel, error)) (*comby.DataModel, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, ok := g.flights[key]
+	if !ok {
+		flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.flights[key] = f
+		go func() {
+			defer cancel()
+			f.result, f.err = fn(flightCtx)
+			g.mu.Lock()
+			g.forget(key, f)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return copyDataModel(f.result), f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// later callers must not join the cancelled download
+			g.forget(key, f)
+			f.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes the flight of key unless it was replaced already. The
+// caller holds g.mu.
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+func copyDataModel(model *comby.DataModel) *comby.DataModel {
+	if model == nil {
+		return nil
+	}
+	result := *model
+	result.Data = append([]byte(nil), model.Data...)
+	return &result
+}
@@ -0,0 +1,92 @@
+package store_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreGetCoalescing(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// setup and init store with coalescing
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithGetCoalescing(true),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set value
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("coalesce-bucket"),
+		comby.DataStoreSetOptionWithObjectName("popular"),
+		comby.DataStoreSetOptionWithData([]byte("popular")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent Gets share one slow download and get the same data, each
+	// in its own copy
+	fake.delay.Store(int64(200 * time.Millisecond))
+	fake.delayed.Store(1)
+	var wg sync.WaitGroup
+	errCh := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			model, err := dataStore.Get(ctx,
+				comby.DataStoreGetOptionWithBucketName("coalesce-bucket"),
+				comby.DataStoreGetOptionWithObjectName("popular"),
+			)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if string(model.Data) != "popular" {
+				t.Errorf("wrong data: %q", model.Data)
+			}
+			model.Data[0] = 'x'
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatal(err)
+	}
+	if gets := fake.gets.Load(); gets != 1 {
+		t.Fatalf("expected 1 download, got %d", gets)
+	}
+
+	// a cancelled Get does not affect others
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := dataStore.Get(cancelledCtx,
+		comby.DataStoreGetOptionWithBucketName("coalesce-bucket"),
+		comby.DataStoreGetOptionWithObjectName("popular"),
+	); err == nil {
+		t.Fatal("expected error for cancelled context")
+	}
+	model, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("coalesce-bucket"),
+		comby.DataStoreGetOptionWithObjectName("popular"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != "popular" {
+		t.Fatalf("wrong data: %q", model.Data)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// SetResult describes the object written by SetWithResult.
+type SetResult struct {
+	// ETag can be passed to DataStoreSetOptionWithMatchETag to update the
+	// object only if nobody else changed it in the meantime.
+	ETag      string
+	VersionID string
+}
+
+// DataStoreSetOptionWithCreateOnly writes the object only if it does not
+// exist yet (If-None-Match: *).
+func DataStoreSetOptionWithCreateOnly() comby.DataStoreSetOption {
+	return func(opt *comby.DataStoreSetOptions) (*comby.DataStoreSetOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_CREATE_ONLY, true)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithCreateOnly lets CopyAcross write the destination
+// only if it does not exist yet (If-None-Match: *). An existing destination
+// fails the copy with ErrPreconditionFailed.
+func DataStoreCopyOptionWithCreateOnly() comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_CREATE_ONLY, true)
+		return opt, nil
+	}
+}
+
+// DataStoreSetOptionWithMatchETag writes the object only if it exists with
+// this ETag (If-Match).
+func DataStoreSetOptionWithMatchETag(etag string) comby.DataStoreSetOption {
+	return func(opt *comby.DataStoreSetOptions) (*comby.DataStoreSetOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_MATCH_ETAG, etag)
+		return opt, nil
+	}
+}
+
+// conditionalHeader returns the precondition headers of a write, nil if it
+// is unconditional.
+func conditionalHeader(attributes *comby.Attributes) http.Header {
+	header := http.Header{}
+	if createOnly, _ := attributeValue[bool](attributes, DATA_STORE_ATTRIBUTE_CREATE_ONLY); createOnly {
+		header.Set("If-None-Match", "*")
+	}
+	if etag, _ := attributeValue[string](attributes, DATA_STORE_ATTRIBUTE_MATCH_ETAG); etag != "" {
+		header.Set("If-Match", quoteETag(etag))
+	}
+	if len(header) == 0 {
+		return nil
+	}
+	return header
+}
+
+// quoteETag returns the ETag in quotes as required in precondition headers.
+func quoteETag(etag string) string {
+	return `"` + strings.Trim(etag, `"`) + `"`
+}
+
+// isConditionalWriteFailed reports whether a conditional write was rejected.
+// Besides 412, S3 answers concurrent conditional writes with 409 and If-Match
+// on a missing object with 404.
+func isConditionalWriteFailed(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "PreconditionFailed", "ConditionalRequestConflict", "NoSuchKey":
+		return true
+	}
+	return false
+}
+
+// requestHeaderKey is the context key of additional request headers.
+type requestHeaderKey struct{}
+
+// withRequestHeader returns a context whose requests carry the header. The
+// client options lack custom headers for some requests, so they are added by
+// headerTransport.
+func withRequestHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, requestHeaderKey{}, header)
+}
+
+// headerTransport adds the headers found in the request context to object
+// uploads: single part uploads and multipart completions.
+type headerTransport struct {
+	base http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header, ok := req.Context().Value(requestHeaderKey{}).(http.Header)
+	if !ok || !isObjectUpload(req) {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+func isObjectUpload(req *http.Request) bool {
+	query := req.URL.Query()
+	switch req.Method {
+	case http.MethodPut:
+		return !query.Has("partNumber") && !query.Has("tagging") && !query.Has("retention") && !query.Has("legal-hold")
+	case http.MethodPost:
+		return query.Has("uploadId")
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreConditionalSet(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(data string, opts ...comby.DataStoreSetOption) (*store.SetResult, error) {
+		return dataStore.SetWithResult(ctx, append([]comby.DataStoreSetOption{
+			comby.DataStoreSetOptionWithBucketName("conditional-bucket"),
+			comby.DataStoreSetOptionWithObjectName("state.json"),
+			comby.DataStoreSetOptionWithContentType("application/json"),
+			comby.DataStoreSetOptionWithData([]byte(data)),
+		}, opts...)...)
+	}
+
+	// create only succeeds once
+	created, err := set(`{"v":1}`, store.DataStoreSetOptionWithCreateOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ETag == "" {
+		t.Fatal("missing ETag")
+	}
+	if _, err := set(`{"v":1}`, store.DataStoreSetOptionWithCreateOnly()); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got %v", err)
+	}
+
+	// compare and swap with the current ETag
+	updated, err := set(`{"v":2}`, store.DataStoreSetOptionWithMatchETag(created.ETag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ETag == created.ETag {
+		t.Fatal("expected new ETag")
+	}
+
+	// a stale ETag is rejected and the object is unchanged
+	if _, err := set(`{"v":3}`, store.DataStoreSetOptionWithMatchETag(created.ETag)); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got %v", err)
+	}
+	model, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("conditional-bucket"),
+		comby.DataStoreGetOptionWithObjectName("state.json"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != `{"v":2}` {
+		t.Fatalf("wrong data: %s", model.Data)
+	}
+
+	// compare and swap of a missing object fails
+	if _, err := dataStore.SetWithResult(ctx,
+		comby.DataStoreSetOptionWithBucketName("conditional-bucket"),
+		comby.DataStoreSetOptionWithObjectName("missing.json"),
+		comby.DataStoreSetOptionWithData([]byte(`{}`)),
+		store.DataStoreSetOptionWithMatchETag(updated.ETag),
+	); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got %v", err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
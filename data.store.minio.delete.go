@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// defaultDeleteConcurrency is the number of buckets processed in parallel by
+// batch deletes unless configured with DataStoreOptionWithDeleteConcurrency.
+const defaultDeleteConcurrency = 4
+
+// DeleteError reports an object that could not be removed by a batch delete.
+type DeleteError struct {
+	BucketName string
+	ObjectName string
+	VersionID  string
+	Err        error
+}
+
+func (e *DeleteError) Error() string {
+	return fmt.Sprintf("failed to remove object %s/%s: %v", e.BucketName, e.ObjectName, e.Err)
+}
+
+func (e *DeleteError) Unwrap() error {
+	return e.Err
+}
+
+// DeleteResult summarizes a batch delete.
+type DeleteResult struct {
+	// Deleted is the number of removed objects.
+	Deleted int
+	// Errors holds one entry per object that could not be removed.
+	Errors []*DeleteError
+}
+
+// DeleteBatch removes the given objects using multi-object delete requests
+// (up to 1000 keys per request). Buckets are processed concurrently. Failures
+// of single objects are reported in the result, the returned error is only
+// set if the whole operation was aborted, e.g. by a cancelled context.
+func (dsm *dataStoreMinio) DeleteBatch(ctx context.Context, objects []*comby.DataModel) (*DeleteResult, error) {
+	var bucketNames []string
+	objectNames := make(map[string][]string)
+	for _, object := range objects {
+		if _, ok := objectNames[object.BucketName]; !ok {
+			bucketNames = append(bucketNames, object.BucketName)
+		}
+		objectNames[object.BucketName] = append(objectNames[object.BucketName], object.ObjectName)
+		dsm.invalidateCache(object.BucketName, object.ObjectName)
+	}
+
+	result := &DeleteResult{}
+	var mu sync.Mutex
+	dsm.forEachBucket(ctx, bucketNames, func(bucketName string) {
+		objectsCh := make(chan minio.ObjectInfo)
+		go func() {
+			defer close(objectsCh)
+			for _, objectName := range objectNames[bucketName] {
+				select {
+				case objectsCh <- minio.ObjectInfo{Key: objectName}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		record := dsm.newAuditRecord(ctx, "DeleteBatch", bucketName, "")
+		removed, errs := dsm.removeObjects(ctx, bucketName, objectsCh, minio.RemoveObjectsOptions{})
+		dsm.auditRemoved(ctx, record, removed, errs)
+		mu.Lock()
+		result.Deleted += len(removed)
+		result.Errors = append(result.Errors, errs...)
+		mu.Unlock()
+	})
+	return result, ctx.Err()
+}
+
+// DeletePrefix removes all objects whose name starts with ObjectName from the
+// bucket BucketName. If no bucket name is given the prefix is removed from
+// all buckets except the audit buckets, which are processed concurrently.
+// Without bucket name the prefix must not be empty, removing everything is
+// left to Reset and its guard. Errors are reported as in DeleteBatch.
+func (dsm *dataStoreMinio) DeletePrefix(ctx context.Context, opts ...comby.DataStoreDeleteOption) (*DeleteResult, error) {
+	deleteOpts := comby.DataStoreDeleteOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&deleteOpts); err != nil {
+			return nil, err
+		}
+	}
+	if deleteOpts.BucketName == "" && deleteOpts.ObjectName == "" {
+		return nil, fmt.Errorf("delete prefix without bucket name and prefix would remove all objects, use Reset instead")
+	}
+	if deleteOpts.BucketName != "" && dsm.isAuditBucket(deleteOpts.BucketName) {
+		return nil, fmt.Errorf("bucket %s holds audit records and is not deleted by prefix", deleteOpts.BucketName)
+	}
+	bucketNames := []string{deleteOpts.BucketName}
+	if deleteOpts.BucketName == "" {
+		buckets, err := dsm.minioClient.ListBuckets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bucketNames = dsm.withoutAuditBuckets(buckets)
+	}
+
+	if dsm.cache != nil {
+		for _, bucketName := range bucketNames {
+			dsm.cache.invalidatePrefix(cacheKey(bucketName, deleteOpts.ObjectName))
+		}
+	}
+
+	result := &DeleteResult{}
+	var mu sync.Mutex
+	dsm.forEachBucket(ctx, bucketNames, func(bucketName string) {
+		var listErrs []*DeleteError
+		objectsCh := make(chan minio.ObjectInfo)
+		go func() {
+			defer close(objectsCh)
+			listCh := dsm.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+				Prefix:    deleteOpts.ObjectName,
+				Recursive: true,
+			})
+			for object := range listCh {
+				if object.Err != nil {
+					listErrs = append(listErrs, &DeleteError{BucketName: bucketName, ObjectName: deleteOpts.ObjectName, Err: object.Err})
+					continue
+				}
+				objectsCh <- object
+			}
+		}()
+		record := dsm.newAuditRecord(ctx, "DeletePrefix", bucketName, deleteOpts.ObjectName)
+		removed, errs := dsm.removeObjects(ctx, bucketName, objectsCh, minio.RemoveObjectsOptions{})
+		dsm.auditRemoved(ctx, record, removed, append(listErrs, errs...))
+		mu.Lock()
+		result.Deleted += len(removed)
+		result.Errors = append(result.Errors, listErrs...)
+		result.Errors = append(result.Errors, errs...)
+		mu.Unlock()
+	})
+	return result, ctx.Err()
+}
+
+// removeObjects removes the objects read from objectsCh from the bucket in
+// batches and returns the results of the removed objects and the failures.
+// It drains objectsCh so the producer never blocks.
+func (dsm *dataStoreMinio) removeObjects(ctx context.Context, bucketName string, objectsCh <-chan minio.ObjectInfo, opts minio.RemoveObjectsOptions) ([]minio.RemoveObjectResult, []*DeleteError) {
+	var removed []minio.RemoveObjectResult
+	var errs []*DeleteError
+	for result := range dsm.minioClient.RemoveObjectsWithResult(ctx, bucketName, objectsCh, opts) {
+		if result.Err != nil {
+			errs = append(errs, &DeleteError{
+				BucketName: bucketName,
+				ObjectName: result.ObjectName,
+				VersionID:  result.ObjectVersionID,
+				Err:        lockError(result.Err),
+			})
+			continue
+		}
+		removed = append(removed, result)
+	}
+	for range objectsCh {
+		// not consumed because the removal was aborted
+	}
+	return removed, errs
+}
+
+// auditRemoved completes the audit record of a batch removal in one bucket
+// with the removed objects and the failures.
+func (dsm *dataStoreMinio) auditRemoved(ctx context.Context, record *AuditRecord, removed []minio.RemoveObjectResult, errs []*DeleteError) {
+	record.Objects = int64(len(removed))
+	for _, result := range removed {
+		record.Keys = append(record.Keys, result.ObjectName)
+	}
+	var err error
+	if len(errs) > 0 {
+		err = fmt.Errorf("%d objects not removed: %w", len(errs), errs[0])
+	}
+	dsm.audit(ctx, record, err)
+}
+
+// forEachBucket calls fn for every bucket with bounded concurrency and waits
+// for all calls to return.
+func (dsm *dataStoreMinio) forEachBucket(ctx context.Context, bucketNames []string, fn func(bucketName string)) {
+	concurrency := defaultDeleteConcurrency
+	if val, ok := attributeValue[int](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_DELETE_CONCURRENCY); ok && val > 0 {
+		concurrency = val
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, bucketName := range bucketNames {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(bucketName string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(bucketName)
+		}(bucketName)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreDeleteBatch(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithDeleteConcurrency(2),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set values in two buckets
+	var objects []*comby.DataModel
+	for _, bucketName := range []string{"batch-bucket1", "batch-bucket2"} {
+		for i := 0; i < 5; i++ {
+			objectName := fmt.Sprintf("user1/upload%d", i)
+			if err := dataStore.Set(ctx,
+				comby.DataStoreSetOptionWithBucketName(bucketName),
+				comby.DataStoreSetOptionWithObjectName(objectName),
+				comby.DataStoreSetOptionWithContentType("text/plain"),
+				comby.DataStoreSetOptionWithData([]byte(objectName)),
+			); err != nil {
+				t.Fatal(err)
+			}
+			objects = append(objects, &comby.DataModel{BucketName: bucketName, ObjectName: objectName})
+		}
+	}
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("batch-bucket1"),
+		comby.DataStoreSetOptionWithObjectName("user2/upload"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData([]byte("other user")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Delete some objects in a batch
+	if result, err := dataStore.DeleteBatch(ctx, objects[:2]); err != nil {
+		t.Fatal(err)
+	} else {
+		if result.Deleted != 2 || len(result.Errors) != 0 {
+			t.Fatalf("wrong batch result: deleted %d, errors %v", result.Deleted, result.Errors)
+		}
+	}
+
+	// Delete the remaining objects of user1 across all buckets
+	if result, err := dataStore.DeletePrefix(ctx,
+		comby.DataStoreDeleteOptionWithObjectName("user1/"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if result.Deleted != 8 || len(result.Errors) != 0 {
+			t.Fatalf("wrong prefix result: deleted %d, errors %v", result.Deleted, result.Errors)
+		}
+	}
+
+	// check totals, only user2 remains
+	if dataStore.Total(ctx) != 1 {
+		t.Fatalf("wrong total after delete: %d", dataStore.Total(ctx))
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
+
+func TestDataStoreDeletePrefixAll(t *testing.T) {
+	ctx := context.Background()
+	_, endpoint := newFakeS3(t)
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err := dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("prefix-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty prefix in all buckets is refused, nothing is removed
+	if _, err := dataStore.DeletePrefix(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("prefix-bucket"),
+		comby.DataStoreGetOptionWithObjectName("a.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// a prefix in all buckets is removed
+	result, err := dataStore.DeletePrefix(ctx, comby.DataStoreDeleteOptionWithObjectName("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 1 || len(result.Errors) != 0 {
+		t.Fatalf("wrong result %+v", result)
+	}
+}
@@ -0,0 +1,417 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+)
+
+// endpointCooldown is the time an endpoint is avoided after a failure unless
+// a health check finds it healthy earlier.
+const endpointCooldown = 10 * time.Second
+
+// healthCheckPath is the unauthenticated liveness probe of MinIO.
+const healthCheckPath = "/minio/health/live"
+
+// DataStoreOptionWithEndpoints adds endpoints serving the same data as the
+// endpoint of the store, e.g. the nodes of a MinIO cluster. Requests go to
+// the healthy endpoint with the lowest latency. Reads fail over to the next
+// one on connection errors, writes only if the connection could not be
+// established, as a write may have been applied before the connection broke.
+func DataStoreOptionWithEndpoints(endpoints ...string) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_ENDPOINTS, endpoints)
+}
+
+// DataStoreOptionWithHealthCheck probes all endpoints in the given interval,
+// so failed endpoints are used again as soon as they recover.
+func DataStoreOptionWithHealthCheck(interval time.Duration) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_HEALTH_CHECK_INTERVAL, interval)
+}
+
+// DataStoreOptionWithHedgedGet sends a second request for an object read by
+// Get if the first one did not answer within after, preferably to another
+// endpoint. The first answer wins, the other request is cancelled.
+func DataStoreOptionWithHedgedGet(after time.Duration) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_HEDGE_AFTER, after)
+}
+
+// initEndpoints wraps the transport with endpoint selection if several
+// endpoints or hedged reads are configured and starts the health checks.
+func (dsm *dataStoreMinio) initEndpoints(base http.RoundTripper) http.RoundTripper {
+	if dsm.stopHealthCheck != nil {
+		dsm.stopHealthCheck()
+		dsm.stopHealthCheck = nil
+	}
+	dsm.endpoints = nil
+	endpoints, _ := attributeValue[[]string](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_ENDPOINTS)
+	hedgeAfter, _ := attributeValue[time.Duration](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_HEDGE_AFTER)
+	if len(endpoints) == 0 && hedgeAfter <= 0 {
+		return base
+	}
+	dsm.endpoints = newEndpointPool(append([]string{dsm.Endpoint}, endpoints...))
+	transport := &failoverTransport{base: base, pool: dsm.endpoints, hedgeAfter: hedgeAfter}
+	if interval, _ := attributeValue[time.Duration](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_HEALTH_CHECK_INTERVAL); interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		dsm.stopHealthCheck = cancel
+		go transport.healthCheck(ctx, dsm.minioOptions.Secure, interval)
+	}
+	return transport
+}
+
+// EndpointStatus describes the health of an endpoint as seen by the store.
+type EndpointStatus struct {
+	Endpoint string
+	Healthy  bool
+	// Failures is the number of consecutive failed requests.
+	Failures int
+	// Latency is the moving average of the response times.
+	Latency   time.Duration
+	LastError string
+}
+
+// Endpoints returns the status of all endpoints, the endpoint of the store
+// first.
+func (dsm *dataStoreMinio) Endpoints() []EndpointStatus {
+	if dsm.endpoints == nil {
+		return []EndpointStatus{{Endpoint: dsm.Endpoint, Healthy: true}}
+	}
+	return dsm.endpoints.status()
+}
+
+// endpointState is the health of an endpoint.
+type endpointState struct {
+	host        string
+	healthy     bool
+	failures    int
+	latency     time.Duration
+	lastFailure time.Time
+	lastError   string
+}
+
+// endpointPool selects endpoints by health and latency.
+type endpointPool struct {
+	mu        sync.Mutex
+	endpoints []*endpointState
+}
+
+func newEndpointPool(hosts []string) *endpointPool {
+	pool := &endpointPool{}
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		pool.endpoints = append(pool.endpoints, &endpointState{host: host, healthy: true})
+	}
+	return pool
+}
+
+// pick returns the endpoint to use next, skipping the excluded ones. Healthy
+// endpoints and endpoints whose cooldown is over are preferred, the fastest
+// of them wins. Otherwise the endpoint failed longest ago is tried. It
+// returns nil if all endpoints are excluded.
+func (p *endpointPool) pick(exclude ...*endpointState) *endpointState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var best, fallback *endpointState
+	for _, e := range p.endpoints {
+		excluded := false
+		for _, x := range exclude {
+			excluded = excluded || x == e
+		}
+		if excluded {
+			continue
+		}
+		if !e.healthy && now.Sub(e.lastFailure) < endpointCooldown {
+			if fallback == nil || e.lastFailure.Before(fallback.lastFailure) {
+				fallback = e
+			}
+			continue
+		}
+		if best == nil || e.latency < best.latency {
+			best = e
+		}
+	}
+	if best == nil {
+		return fallback
+	}
+	return best
+}
+
+func (p *endpointPool) success(e *endpointState, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observeLocked(e, latency)
+	e.healthy = true
+	e.failures = 0
+}
+
+// observe records the latency of a request cancelled before it answered,
+// e.g. the losing request of a hedged read.
+func (p *endpointPool) observe(e *endpointState, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.observeLocked(e, latency)
+}
+
+func (p *endpointPool) observeLocked(e *endpointState, latency time.Duration) {
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = (4*e.latency + latency) / 5
+	}
+}
+
+func (p *endpointPool) failure(e *endpointState, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.healthy = false
+	e.failures++
+	e.lastFailure = time.Now()
+	e.lastError = err.Error()
+}
+
+func (p *endpointPool) status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		status = append(status, EndpointStatus{
+			Endpoint:  e.host,
+			Healthy:   e.healthy,
+			Failures:  e.failures,
+			Latency:   e.latency,
+			LastError: e.lastError,
+		})
+	}
+	return status
+}
+
+// hedgedReadKey is the context key marking reads that may be hedged.
+type hedgedReadKey struct{}
+
+func withHedgedRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgedReadKey{}, true)
+}
+
+// failoverTransport sends requests to the endpoints of a pool. Requests are
+// signed for the endpoint of the store, which is kept as Host header, so any
+// node of a cluster accepts them.
+type failoverTransport struct {
+	base       http.RoundTripper
+	pool       *endpointPool
+	hedgeAfter time.Duration
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if hedged, _ := req.Context().Value(hedgedReadKey{}).(bool); hedged && t.hedgeAfter > 0 && req.Method == http.MethodGet {
+		return t.hedgedRoundTrip(req)
+	}
+	var tried []*endpointState
+	for {
+		e := t.pool.pick(tried...)
+		tried = append(tried, e)
+		resp, err := t.send(req, e)
+		if err == nil || !isConnectionError(err) || len(tried) == len(t.pool.endpoints) {
+			return resp, err
+		}
+		// a write sent again could fail its own condition or apply twice
+		if req.Method != http.MethodGet && req.Method != http.MethodHead && !isDialError(err) {
+			return resp, err
+		}
+		// the body is consumed, leave the retry to the client
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+	}
+}
+
+// hedgedRoundTrip sends the request a second time if the first one did not
+// answer within hedgeAfter or failed, and returns the first good answer.
+func (t *failoverTransport) hedgedRoundTrip(req *http.Request) (*http.Response, error) {
+	type result struct {
+		attempt int
+		resp    *http.Response
+		err     error
+	}
+	results := make(chan result, 2)
+	var cancels []context.CancelFunc
+	var tried []*endpointState
+	launch := func() {
+		e := t.pool.pick(tried...)
+		if e == nil {
+			e = tried[0]
+		}
+		tried = append(tried, e)
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels = append(cancels, cancel)
+		attempt := len(cancels) - 1
+		go func() {
+			resp, err := t.send(req.WithContext(ctx), e)
+			results <- result{attempt: attempt, resp: resp, err: err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(t.hedgeAfter)
+	defer timer.Stop()
+	received := 0
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) == 1 {
+				launch()
+			}
+		case r := <-results:
+			received++
+			good := r.err == nil && r.resp.StatusCode < http.StatusInternalServerError
+			if !good && len(cancels) == 1 {
+				// fail over right away instead of waiting for the timer
+				if r.resp != nil {
+					r.resp.Body.Close()
+				}
+				cancels[0]()
+				launch()
+				continue
+			}
+			if !good && received < len(cancels) {
+				if r.resp != nil {
+					r.resp.Body.Close()
+				}
+				cancels[r.attempt]()
+				continue
+			}
+			// cancel and drain the other request
+			for i, cancel := range cancels {
+				if i != r.attempt {
+					cancel()
+				}
+			}
+			if pending := len(cancels) - received; pending > 0 {
+				go func() {
+					if other := <-results; other.resp != nil {
+						other.resp.Body.Close()
+					}
+				}()
+			}
+			if r.err != nil {
+				cancels[r.attempt]()
+				return nil, r.err
+			}
+			r.resp.Body = &cancelOnClose{ReadCloser: r.resp.Body, cancel: cancels[r.attempt]}
+			return r.resp, nil
+		}
+	}
+}
+
+// send sends the request to the endpoint and records the outcome.
+func (t *failoverTransport) send(req *http.Request, e *endpointState) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Host = req.URL.Host
+	if req.Host != "" {
+		r.Host = req.Host
+	}
+	r.URL.Host = e.host
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	switch {
+	case errors.Is(err, context.Canceled):
+		t.pool.observe(e, time.Since(start))
+	case err != nil:
+		if isConnectionError(err) {
+			t.pool.failure(e, err)
+		}
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		t.pool.failure(e, errors.New(resp.Status))
+	default:
+		t.pool.success(e, time.Since(start))
+	}
+	return resp, err
+}
+
+// healthCheck probes all endpoints until ctx is done.
+func (t *failoverTransport) healthCheck(ctx context.Context, secure bool, interval time.Duration) {
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	client := &http.Client{Transport: t.base, Timeout: min(interval, 5*time.Second)}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t.pool.mu.Lock()
+		endpoints := append([]*endpointState(nil), t.pool.endpoints...)
+		t.pool.mu.Unlock()
+		for _, e := range endpoints {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+e.host+healthCheckPath, nil)
+			if err != nil {
+				continue
+			}
+			start := time.Now()
+			resp, err := client.Do(req)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				t.pool.failure(e, err)
+				continue
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.pool.failure(e, errors.New(resp.Status))
+				continue
+			}
+			t.pool.success(e, time.Since(start))
+		}
+	}
+}
+
+// isConnectionError reports whether the endpoint could not be reached or
+// dropped the connection.
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// isDialError reports whether the connection to the endpoint could not be
+// established, so the request never reached it.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// cancelOnClose releases the context of a request when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,124 @@
+package store_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreEndpointsFailover(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// an endpoint refusing connections
+	down := httptest.NewServer(nil)
+	downEndpoint := strings.TrimPrefix(down.URL, "http://")
+	down.Close()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(downEndpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithEndpoints(endpoint),
+		store.DataStoreOptionWithHealthCheck(10*time.Millisecond),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close(ctx)
+
+	// requests fail over to the available endpoint
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("failover-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	model, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("failover-bucket"),
+		comby.DataStoreGetOptionWithObjectName("a.txt"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != "alpha" {
+		t.Fatalf("wrong data: %s", model.Data)
+	}
+	endpoints := dataStore.Endpoints()
+	if len(endpoints) != 2 || endpoints[0].Healthy || !endpoints[1].Healthy {
+		t.Fatalf("wrong endpoint status: %+v", endpoints)
+	}
+
+	// health checks notice failures and recoveries
+	waitHealthy := func(healthy bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for dataStore.Endpoints()[1].Healthy != healthy {
+			if time.Now().After(deadline) {
+				t.Fatalf("endpoint not healthy=%t: %+v", healthy, dataStore.Endpoints())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	fake.deny.Store(true)
+	waitHealthy(false)
+	fake.deny.Store(false)
+	waitHealthy(true)
+}
+
+func TestDataStoreHedgedGet(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithHedgedGet(20*time.Millisecond),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("hedge-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first read hangs, the hedged one answers
+	fake.delay.Store(int64(time.Second))
+	fake.delayed.Store(1)
+	start := time.Now()
+	model, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("hedge-bucket"),
+		comby.DataStoreGetOptionWithObjectName("a.txt"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != "alpha" {
+		t.Fatalf("wrong data: %s", model.Data)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("hedged Get took %s", elapsed)
+	}
+	if gets := fake.gets.Load(); gets != 2 {
+		t.Fatalf("expected 2 reads, got %d", gets)
+	}
+
+	// fast reads are not hedged
+	if _, err = dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("hedge-bucket"),
+		comby.DataStoreGetOptionWithObjectName("a.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if gets := fake.gets.Load(); gets != 3 {
+		t.Fatalf("expected 3 reads, got %d", gets)
+	}
+}
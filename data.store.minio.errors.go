@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrPreconditionFailed is returned when a conditional operation was rejected
+// because the object did not match the given condition.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// isPreconditionFailed reports whether err is a precondition error returned
+// by the server.
+func isPreconditionFailed(err error) bool {
+	return minio.ToErrorResponse(err).Code == "PreconditionFailed"
+}
+
+// ErrResetNotAllowed is returned by Reset if resetting the endpoint was not
+// explicitly allowed.
+var ErrResetNotAllowed = errors.New("reset not allowed")
+
+// ErrObjectLocked is returned when an object version cannot be removed or
+// changed because of its retention or legal hold.
+var ErrObjectLocked = errors.New("object is locked")
+
+// lockError wraps err with ErrObjectLocked if the server rejected the request
+// because of an object lock.
+func lockError(err error) error {
+	if err != nil && isObjectLocked(err) {
+		return fmt.Errorf("%w: %w", ErrObjectLocked, err)
+	}
+	return err
+}
+
+// isObjectLocked reports whether err is an object lock error returned by the
+// server. MinIO and S3 use different codes for it, so the message is checked.
+func isObjectLocked(err error) bool {
+	errResp := minio.ToErrorResponse(err)
+	switch errResp.Code {
+	case "ObjectLocked":
+		return true
+	case "InvalidRequest", "AccessDenied":
+		message := strings.ToLower(errResp.Message)
+		return strings.Contains(message, "worm") ||
+			strings.Contains(message, "object lock") ||
+			strings.Contains(message, "retention") ||
+			strings.Contains(message, "legal hold")
+	}
+	return false
+}
+
+// ErrLeaseHeld is returned by AcquireLease if another owner holds the lease.
+var ErrLeaseHeld = errors.New("lease held")
+
+// ErrLeaseLost is returned when renewing or releasing a lease that was taken
+// over by another owner.
+var ErrLeaseLost = errors.New("lease lost")
@@ -0,0 +1,501 @@
+package store_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeObject is an object stored by fakeS3.
+type fakeObject struct {
+	data         []byte
+	etag         string
+	contentType  string
+	metadata     http.Header
+	lastModified time.Time
+}
+
+// fakeS3 is a minimal in-memory S3 server supporting buckets, objects with
+// metadata, conditional writes and listings, enough to run tests without
+// MinIO.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*fakeObject
+	// locks holds the object lock configuration of buckets created with
+	// object locking
+	locks map[string][]byte
+	// policies holds the bucket policy documents
+	policies map[string]string
+
+	// deny rejects all requests with AccessDenied, e.g. to fail replication
+	deny atomic.Bool
+	// failStatus answers all requests with this server error if set
+	failStatus atomic.Int64
+	// copies enables server-side copies, answered with NotImplemented
+	// otherwise
+	copies atomic.Bool
+	// failDeletes answers the next object deletes with an error after
+	// deleting the object, so the client cannot tell whether it is gone
+	failDeletes atomic.Int64
+	// gets counts the object reads, notModified those answered with
+	// NotModified
+	gets        atomic.Int64
+	notModified atomic.Int64
+	// delay is added to the next delayed object reads
+	delay   atomic.Int64
+	delayed atomic.Int64
+	// unavailable answers the next requests with ServiceUnavailable
+	unavailable atomic.Int64
+	// requests numbers the requests, returned as request ID
+	requests atomic.Int64
+	// listens counts the bucket notification requests, a value sent to
+	// hangup ends an open one without error
+	listens atomic.Int64
+	hangup  chan struct{}
+}
+
+// newFakeS3 starts a fake S3 server and returns it with its endpoint
+// (host:port).
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	fake := &fakeS3{
+		buckets:  make(map[string]map[string]*fakeObject),
+		locks:    make(map[string][]byte),
+		policies: make(map[string]string),
+		hangup:   make(chan struct{}),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, strings.TrimPrefix(server.URL, "http://")
+}
+
+// object returns a copy of the data of an object, nil if it does not exist.
+func (f *fakeS3) object(bucketName, objectName string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if object, ok := f.buckets[bucketName][objectName]; ok {
+		return append([]byte{}, object.data...)
+	}
+	return nil
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = decodeAWSChunked(body)
+	}
+	w.Header().Set("X-Amz-Request-Id", strconv.FormatInt(f.requests.Add(1), 10))
+	if f.unavailable.Add(-1) >= 0 {
+		f.error(w, http.StatusServiceUnavailable, "SlowDown")
+		return
+	}
+	if f.deny.Load() {
+		f.error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	if status := f.failStatus.Load(); status != 0 {
+		f.error(w, int(status), strings.ReplaceAll(http.StatusText(int(status)), " ", ""))
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("location") {
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+		return
+	}
+	if r.URL.Path == "/minio/health/live" {
+		return
+	}
+	if r.Method == http.MethodGet && r.URL.Path == "/" {
+		f.listBuckets(w)
+		return
+	}
+	if r.Method == http.MethodGet && query.Has("events") {
+		f.listen(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	bucketName, objectName, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	bucket, bucketExists := f.buckets[bucketName]
+	if objectName == "" && query.Has("object-lock") {
+		defer f.mu.Unlock()
+		f.objectLock(w, r, bucketName, body)
+		return
+	}
+	if objectName == "" && query.Has("policy") && bucketExists {
+		defer f.mu.Unlock()
+		f.policy(w, r, bucketName, body)
+		return
+	}
+	if objectName == "" {
+		defer f.mu.Unlock()
+		switch r.Method {
+		case http.MethodHead:
+			if !bucketExists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			if !bucketExists {
+				f.buckets[bucketName] = make(map[string]*fakeObject)
+				if r.Header.Get("X-Amz-Bucket-Object-Lock-Enabled") == "true" {
+					f.locks[bucketName] = []byte(`<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`)
+				}
+			}
+		case http.MethodGet:
+			if !bucketExists {
+				f.error(w, http.StatusNotFound, "NoSuchBucket")
+				return
+			}
+			f.listObjects(w, bucketName, bucket, query.Get("prefix"), query.Get("metadata") == "true")
+		case http.MethodDelete:
+			delete(f.buckets, bucketName)
+			delete(f.locks, bucketName)
+			delete(f.policies, bucketName)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			if !bucketExists || !query.Has("delete") {
+				f.error(w, http.StatusNotImplemented, "NotImplemented")
+				return
+			}
+			f.deleteObjects(w, bucket, body)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+	if !bucketExists {
+		f.mu.Unlock()
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	object, objectExists := bucket[objectName]
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		f.mu.Unlock()
+		if !objectExists {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Method == http.MethodGet {
+			f.gets.Add(1)
+			if f.delayed.Add(-1) >= 0 {
+				time.Sleep(time.Duration(f.delay.Load()))
+			}
+		}
+		if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != object.etag {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" && strings.Trim(match, `"`) == object.etag {
+			if r.Method == http.MethodGet {
+				f.notModified.Add(1)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		for key, values := range object.metadata {
+			w.Header()[key] = values
+		}
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+		w.Header().Set("Last-Modified", object.lastModified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case http.MethodPut:
+		defer f.mu.Unlock()
+		if r.Header.Get("If-None-Match") == "*" && objectExists {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" {
+			if !objectExists {
+				f.error(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			if strings.Trim(match, `"`) != object.etag {
+				f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			if !f.copies.Load() {
+				w.WriteHeader(http.StatusNotImplemented)
+				return
+			}
+			f.copyObject(w, r, bucket, objectName, source)
+			return
+		}
+		// like S3, the ETag of a single part upload is the MD5 of its data
+		sum := md5.Sum(body)
+		object = &fakeObject{
+			data:         body,
+			etag:         hex.EncodeToString(sum[:]),
+			contentType:  r.Header.Get("Content-Type"),
+			metadata:     http.Header{},
+			lastModified: time.Now(),
+		}
+		for key, values := range r.Header {
+			if strings.HasPrefix(key, "X-Amz-Meta-") {
+				object.metadata[key] = values
+			}
+		}
+		bucket[objectName] = object
+		w.Header().Set("ETag", `"`+object.etag+`"`)
+	case http.MethodDelete:
+		defer f.mu.Unlock()
+		delete(bucket, objectName)
+		if f.failDeletes.Add(-1) >= 0 {
+			f.error(w, http.StatusConflict, "OperationAborted")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// copyObject copies the source object to objectName of bucket, keeping or
+// replacing the metadata as requested.
+func (f *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, bucket map[string]*fakeObject, objectName, source string) {
+	source, _ = url.PathUnescape(source)
+	source, _, _ = strings.Cut(source, "?")
+	srcBucketName, srcObjectName, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	src, ok := f.buckets[srcBucketName][srcObjectName]
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && strings.Trim(match, `"`) != src.etag {
+		f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); match != "" && strings.Trim(match, `"`) == src.etag {
+		f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	object := &fakeObject{
+		data:         src.data,
+		etag:         src.etag,
+		contentType:  src.contentType,
+		metadata:     src.metadata.Clone(),
+		lastModified: time.Now(),
+	}
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		object.contentType = r.Header.Get("Content-Type")
+		object.metadata = http.Header{}
+		for key, values := range r.Header {
+			if strings.HasPrefix(key, "X-Amz-Meta-") {
+				object.metadata[key] = values
+			}
+		}
+	}
+	bucket[objectName] = object
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<CopyObjectResult><ETag>"%s"</ETag><LastModified>%s</LastModified></CopyObjectResult>`,
+		object.etag, object.lastModified.UTC().Format(time.RFC3339))
+}
+
+// listen holds a bucket notification request open without sending events
+// until the client goes away or the request is hung up.
+func (f *fakeS3) listen(w http.ResponseWriter, r *http.Request) {
+	f.listens.Add(1)
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	select {
+	case <-r.Context().Done():
+	case <-f.hangup:
+	}
+}
+
+// deleteObjects handles a multi-object delete request. The caller holds
+// f.mu.
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, bucket map[string]*fakeObject, body []byte) {
+	request := struct {
+		Objects []struct{ Key string } `xml:"Object"`
+	}{}
+	if err := xml.Unmarshal(body, &request); err != nil {
+		f.error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	type deleted struct{ Key string }
+	response := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		Deleted []deleted
+	}{}
+	for _, object := range request.Objects {
+		delete(bucket, object.Key)
+		response.Deleted = append(response.Deleted, deleted{Key: object.Key})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+// policy reads, replaces or removes the policy of a bucket. The caller holds
+// f.mu.
+func (f *fakeS3) policy(w http.ResponseWriter, r *http.Request, bucketName string, body []byte) {
+	switch r.Method {
+	case http.MethodGet:
+		policy, ok := f.policies[bucketName]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchBucketPolicy")
+			return
+		}
+		fmt.Fprint(w, policy)
+	case http.MethodPut:
+		f.policies[bucketName] = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		delete(f.policies, bucketName)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// setPolicy sets the policy document of a bucket as another client would.
+func (f *fakeS3) setPolicy(bucketName, policy string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policies[bucketName] = policy
+}
+
+// objectLock reads or replaces the object lock configuration of a bucket.
+// The caller holds f.mu.
+func (f *fakeS3) objectLock(w http.ResponseWriter, r *http.Request, bucketName string, body []byte) {
+	config, ok := f.locks[bucketName]
+	switch {
+	case !ok:
+		f.error(w, http.StatusNotFound, "ObjectLockConfigurationNotFoundError")
+	case r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(config)
+	case r.Method == http.MethodPut:
+		f.locks[bucketName] = body
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// lock returns the object lock configuration of a bucket, nil if the bucket
+// was not created with object locking.
+func (f *fakeS3) lock(bucketName string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.locks[bucketName]
+}
+
+func (f *fakeS3) listBuckets(w http.ResponseWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	type bucket struct {
+		Name         string
+		CreationDate string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}{}
+	for name := range f.buckets {
+		result.Buckets = append(result.Buckets, bucket{Name: name, CreationDate: time.Now().UTC().Format(time.RFC3339)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// listObjects answers ListObjectsV2 without pagination. The caller holds
+// f.mu.
+func (f *fakeS3) listObjects(w http.ResponseWriter, bucketName string, bucket map[string]*fakeObject, prefix string, withMetadata bool) {
+	type metadataEntry struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	}
+	type contents struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+		UserMetadata *struct {
+			Entries []metadataEntry
+		} `xml:",omitempty"`
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []contents
+	}{Name: bucketName, Prefix: prefix}
+	for key, object := range bucket {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry := contents{
+			Key:          key,
+			LastModified: object.lastModified.UTC().Format(time.RFC3339Nano),
+			ETag:         `"` + object.etag + `"`,
+			Size:         len(object.data),
+			StorageClass: "STANDARD",
+		}
+		if withMetadata {
+			entry.UserMetadata = &struct{ Entries []metadataEntry }{}
+			for name, values := range object.metadata {
+				entry.UserMetadata.Entries = append(entry.UserMetadata.Entries, metadataEntry{XMLName: xml.Name{Local: name}, Value: values[0]})
+			}
+		}
+		result.Contents = append(result.Contents, entry)
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// decodeAWSChunked decodes a body sent with a streaming signature.
+func decodeAWSChunked(body []byte) []byte {
+	var data []byte
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return data
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 {
+			return data
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return data
+		}
+		data = append(data, chunk...)
+		reader.ReadString('\n')
+	}
+}
@@ -7,11 +7,15 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gradientzero/comby/v2"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type dataStoreMinio struct {
@@ -24,10 +28,87 @@ type dataStoreMinio struct {
 
 	// info
 	dataStoreInfoModel *comby.DataStoreInfoModel
+	// result of the last Info, read by the metrics
+	lastInfo atomic.Pointer[comby.DataStoreInfoModel]
+
+	// buckets whose policy allows public objects
+	publicBuckets sync.Map
+	// buckets of audit sinks, skipped by Reset and DeletePrefix
+	auditBuckets sync.Map
+	// buckets of leases, skipped by ReconcileBucketPolicies
+	leaseBuckets sync.Map
+
+	// read-through cache of Get, nil if disabled
+	cache *objectCache
+	// concurrent Gets sharing a download
+	getFlights flightGroup
+
+	// endpoint selection, nil if only the endpoint of the store is used
+	endpoints       *endpointPool
+	stopHealthCheck context.CancelFunc
+
+	// connections of the transport, counted if metrics are configured
+	connections connectionStats
+}
+
+// DataStoreMinio extends comby.DataStore with operations only available on
+// the MinIO store.
+type DataStoreMinio interface {
+	comby.DataStore
+
+	// Move copies an object, verifies the copy and removes the source.
+	Move(ctx context.Context, opts ...comby.DataStoreCopyOption) error
+	// MovePrefix moves all objects below the source prefix to the
+	// destination prefix and returns the number of moved objects.
+	MovePrefix(ctx context.Context, opts ...comby.DataStoreCopyOption) (int, error)
+	// SetWithResult writes an object and returns its new ETag.
+	SetWithResult(ctx context.Context, opts ...comby.DataStoreSetOption) (*SetResult, error)
+	// DeleteBatch removes many objects with multi-object delete requests.
+	DeleteBatch(ctx context.Context, objects []*comby.DataModel) (*DeleteResult, error)
+	// DeletePrefix removes all objects below a prefix.
+	DeletePrefix(ctx context.Context, opts ...comby.DataStoreDeleteOption) (*DeleteResult, error)
+	// ListVersions lists all versions of an object, newest first.
+	ListVersions(ctx context.Context, opts ...comby.DataStoreGetOption) ([]*ObjectVersion, error)
+	// GetVersion gets a specific version of an object.
+	GetVersion(ctx context.Context, versionID string, opts ...comby.DataStoreGetOption) (*comby.DataModel, error)
+	// DeleteVersion permanently removes a specific version of an object.
+	DeleteVersion(ctx context.Context, versionID string, opts ...comby.DataStoreDeleteOption) error
+	// RestoreVersion makes a copy of an older version the current version.
+	RestoreVersion(ctx context.Context, versionID string, opts ...comby.DataStoreGetOption) error
+	// Stat returns the metadata of an object including its lock state.
+	Stat(ctx context.Context, opts ...comby.DataStoreGetOption) (*ObjectStat, error)
+	// SetRetention changes the retention of an existing object.
+	SetRetention(ctx context.Context, retention ObjectRetention, opts ...comby.DataStoreGetOption) error
+	// SetLegalHold places or releases a legal hold on an existing object.
+	SetLegalHold(ctx context.Context, enabled bool, opts ...comby.DataStoreGetOption) error
+	// ResetWithResult removes all buckets like Reset and reports in detail
+	// what was removed and what was not.
+	ResetWithResult(ctx context.Context) (*ResetResult, error)
+	// ResetDryRun reports what Reset would remove without removing anything.
+	ResetDryRun(ctx context.Context) (*ResetPlan, error)
+	// GetLifecycle returns the lifecycle rules of a bucket.
+	GetLifecycle(ctx context.Context, bucketName string) ([]LifecycleRule, error)
+	// SetLifecycle replaces the lifecycle rules of a bucket.
+	SetLifecycle(ctx context.Context, bucketName string, rules ...LifecycleRule) error
+	// GetBucketPolicy returns the policy of a bucket.
+	GetBucketPolicy(ctx context.Context, bucketName string) (*BucketPolicy, error)
+	// SetBucketPolicy replaces the policy of a bucket.
+	SetBucketPolicy(ctx context.Context, bucketName string, policy *BucketPolicy) error
+	// ReconcileBucketPolicies applies the configured policy to all buckets.
+	ReconcileBucketPolicies(ctx context.Context) (int, error)
+	// PublicURL returns the direct URL of a public object.
+	PublicURL(bucketName, objectName string) string
+	// Watch calls handler for objects created or removed in a bucket.
+	Watch(ctx context.Context, bucketName string, handler func(ObjectEvent), opts ...WatchOption) error
+	// AcquireLease acquires a lease for mutual exclusion between processes.
+	AcquireLease(ctx context.Context, bucketName, objectName, owner string, ttl time.Duration) (*Lease, error)
+	// Endpoints returns the health of the endpoints used by the store.
+	Endpoints() []EndpointStatus
 }
 
 // Make sure it implements interfaces
 var _ comby.DataStore = (*dataStoreMinio)(nil)
+var _ DataStoreMinio = (*dataStoreMinio)(nil)
 
 func NewDataStoreMinio(
 	Endpoint string,
@@ -35,7 +116,7 @@ func NewDataStoreMinio(
 	AccessKeyId string,
 	SecretAccessKey string,
 	opts ...comby.DataStoreOption,
-) comby.DataStore {
+) DataStoreMinio {
 	dsm := &dataStoreMinio{
 		Endpoint: Endpoint,
 		options:  comby.DataStoreOptions{},
@@ -57,12 +138,14 @@ func NewDataStoreMinio(
 }
 
 // fullfilling DataStore interface
-func (dsm *dataStoreMinio) Init(ctx context.Context, opts ...comby.DataStoreOption) error {
+func (dsm *dataStoreMinio) Init(ctx context.Context, opts ...comby.DataStoreOption) (err error) {
 	for _, opt := range opts {
 		if _, err := opt(&dsm.options); err != nil {
 			return err
 		}
 	}
+	_, op := dsm.startOperation(ctx, "Init", "", "")
+	defer func() { op.end(err) }()
 
 	// Configure HTTP transport with pool settings from options.
 	maxIdleConns := 20
@@ -77,29 +160,71 @@ func (dsm *dataStoreMinio) Init(ctx context.Context, opts ...comby.DataStoreOpti
 	if dsm.options.IdleConnTimeout > 0 {
 		idleConnTimeout = dsm.options.IdleConnTimeout
 	}
-	dsm.minioOptions.Transport = &http.Transport{
+	transport := dsm.instrumentTransport(&http.Transport{
 		MaxIdleConns:        maxIdleConns,
 		MaxIdleConnsPerHost: maxIdleConnsPerHost,
 		IdleConnTimeout:     idleConnTimeout,
-	}
+	})
+	transport = dsm.initEndpoints(dsm.loggingTransport(transport))
+	dsm.minioOptions.Transport = &headerTransport{base: &watchTransport{base: dsm.tracingTransport(transport)}}
+	dsm.metrics().register(dsm)
 
-	var err error
+	dsm.cache, err = newCache(dsm.options.Attributes)
+	if err != nil {
+		return err
+	}
 	dsm.minioClient, err = minio.New(dsm.Endpoint, dsm.minioOptions)
 	return err
 }
 
-func (dsm *dataStoreMinio) Get(ctx context.Context, opts ...comby.DataStoreGetOption) (*comby.DataModel, error) {
+func (dsm *dataStoreMinio) Get(ctx context.Context, opts ...comby.DataStoreGetOption) (model *comby.DataModel, err error) {
 	getOpts := comby.DataStoreGetOptions{}
 	for _, opt := range opts {
 		if _, err := opt(&getOpts); err != nil {
 			return nil, err
 		}
 	}
-	var err error
+	ctx, op := dsm.startOperation(ctx, "Get", getOpts.BucketName, getOpts.ObjectName)
+	defer func() {
+		if model != nil {
+			op.read(len(model.Data))
+		}
+		op.end(err)
+	}()
 	opts2 := minio.GetObjectOptions{
 		// ContentType: contentType,
 	}
-	minioObject, err := dsm.minioClient.GetObject(ctx, getOpts.BucketName, getOpts.ObjectName, opts2)
+	return dsm.getObject(ctx, getOpts.BucketName, getOpts.ObjectName, opts2)
+}
+
+// getObject downloads and decrypts an object, sharing the download with
+// concurrent calls for the same object version if enabled.
+func (dsm *dataStoreMinio) getObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*comby.DataModel, error) {
+	if coalescing, _ := attributeValue[bool](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_GET_COALESCING); coalescing {
+		key := cacheKey(bucketName, objectName) + "?versionId=" + opts.VersionID
+		return dsm.getFlights.do(ctx, key, func(ctx context.Context) (*comby.DataModel, error) {
+			return dsm.fetchObject(ctx, bucketName, objectName, opts)
+		})
+	}
+	return dsm.fetchObject(ctx, bucketName, objectName, opts)
+}
+
+// fetchObject downloads and decrypts an object, using the cache if enabled.
+func (dsm *dataStoreMinio) fetchObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*comby.DataModel, error) {
+	// only current versions are cached
+	var cached *cacheEntry
+	key := cacheKey(bucketName, objectName)
+	useCache := dsm.cache != nil && opts.VersionID == ""
+	if useCache {
+		if cached = dsm.cache.get(key); cached != nil {
+			if err := opts.SetMatchETagExcept(cached.etag); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the download may be hedged if enabled
+	minioObject, err := dsm.minioClient.GetObject(withHedgedRead(ctx), bucketName, objectName, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -107,71 +232,117 @@ func (dsm *dataStoreMinio) Get(ctx context.Context, opts ...comby.DataStoreGetOp
 
 	bytes, err := io.ReadAll(minioObject)
 	if err != nil {
+		if cached != nil && minio.ToErrorResponse(err).StatusCode == http.StatusNotModified {
+			return dsm.cachedDataModel(bucketName, objectName, cached)
+		}
+		if useCache {
+			dsm.cache.invalidate(key)
+		}
 		return nil, err
 	}
 
 	result := &comby.DataModel{
-		BucketName: getOpts.BucketName,
-		ObjectName: getOpts.ObjectName,
+		BucketName: bucketName,
+		ObjectName: objectName,
 		Data:       bytes,
 	}
 
 	// decrypt data if crypto service is provided
+	entry := &cacheEntry{key: key, data: result.Data}
 	if dsm.options.CryptoService != nil && len(result.Data) > 0 {
-		decryptedData, err := dsm.options.CryptoService.Decrypt(result.Data)
+		decryptedData, err := dsm.decrypt(result.Data)
 		if err != nil {
 			return result, fmt.Errorf("'%s' failed to decrypt data: %w", dsm.String(), err)
 		}
 		result.Data = decryptedData
+		if decrypted, _ := attributeValue[bool](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_CACHE_DECRYPTED); decrypted {
+			entry.data = decryptedData
+			entry.decrypted = true
+		}
 	}
 
+	if useCache {
+		if objectInfo, err := minioObject.Stat(); err == nil && objectInfo.ETag != "" {
+			// the cache keeps its own copy, the caller may modify the data
+			entry.etag = objectInfo.ETag
+			entry.data = append([]byte(nil), entry.data...)
+			dsm.cache.put(entry)
+		}
+	}
 	return result, nil
 }
 
+// cachedDataModel returns a copy of a cached object, decrypted if needed.
+func (dsm *dataStoreMinio) cachedDataModel(bucketName, objectName string, cached *cacheEntry) (*comby.DataModel, error) {
+	result := &comby.DataModel{
+		BucketName: bucketName,
+		ObjectName: objectName,
+		Data:       append([]byte(nil), cached.data...),
+	}
+	if !cached.decrypted && dsm.options.CryptoService != nil && len(result.Data) > 0 {
+		decryptedData, err := dsm.decrypt(result.Data)
+		if err != nil {
+			return result, fmt.Errorf("'%s' failed to decrypt data: %w", dsm.String(), err)
+		}
+		result.Data = decryptedData
+	}
+	return result, nil
+}
+
+// encrypt encrypts data with the crypto service of the store.
+func (dsm *dataStoreMinio) encrypt(data []byte) ([]byte, error) {
+	defer dsm.metrics().observeCrypto(dsm, "encrypt", time.Now())
+	return dsm.options.CryptoService.Encrypt(data)
+}
+
+// decrypt decrypts data with the crypto service of the store.
+func (dsm *dataStoreMinio) decrypt(data []byte) ([]byte, error) {
+	defer dsm.metrics().observeCrypto(dsm, "decrypt", time.Now())
+	return dsm.options.CryptoService.Decrypt(data)
+}
+
+// invalidateCache removes an object from the cache, if enabled.
+func (dsm *dataStoreMinio) invalidateCache(bucketName, objectName string) {
+	if dsm.cache != nil {
+		dsm.cache.invalidate(cacheKey(bucketName, objectName))
+	}
+}
+
 func (dsm *dataStoreMinio) Set(ctx context.Context, opts ...comby.DataStoreSetOption) error {
+	_, err := dsm.SetWithResult(ctx, opts...)
+	return err
+}
+
+// SetWithResult writes an object like Set and returns its new ETag and
+// version. Conditional writes fail with ErrPreconditionFailed.
+func (dsm *dataStoreMinio) SetWithResult(ctx context.Context, opts ...comby.DataStoreSetOption) (result *SetResult, err error) {
 	setOpts := comby.DataStoreSetOptions{
 		Attributes: comby.NewAttributes(),
 	}
 	for _, opt := range opts {
 		if _, err := opt(&setOpts); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	var err error
+	ctx, op := dsm.startOperation(ctx, "Set", setOpts.BucketName, setOpts.ObjectName)
+	op.written(len(setOpts.Data))
+	defer func() { op.end(err) }()
+	record := dsm.newAuditRecord(ctx, "Set", setOpts.BucketName, setOpts.ObjectName)
+	defer func() { dsm.audit(ctx, record, err) }()
+
 	// ensure bucket exists
-	// Note: some S3-compatible providers (e.g., Hetzner Object Storage) return
-	// unexpected errors like NoSuchKey instead of a clean 404 for non-existent
-	// buckets. We treat any BucketExists error as "bucket does not exist" and
-	// attempt to create it.
-	bucketExists, err := dsm.minioClient.BucketExists(ctx, setOpts.BucketName)
-	if err != nil {
-		bucketExists = false
-	}
-	if !bucketExists {
-		isBucketPublic := false
-		if _val := setOpts.Attributes.Get(comby.DATA_STORE_ATTRIBUTE_IS_PUBLIC); _val != nil {
-			switch val := _val.(type) {
-			case bool:
-				isBucketPublic = val
-			}
-		}
-		makeBucketOptions := minio.MakeBucketOptions{
-			Region:        dsm.options.BucketRegion,
-			ObjectLocking: dsm.options.BucketObjectLocking,
-		}
-		if err = dsm.createBucket(ctx, setOpts.BucketName, isBucketPublic, makeBucketOptions); err != nil {
-			return fmt.Errorf("MakeBucket(%s, region=%q, objectLocking=%t): %w",
-				setOpts.BucketName, dsm.options.BucketRegion, dsm.options.BucketObjectLocking, err)
-		}
+	if err = dsm.ensureBucket(ctx, setOpts.BucketName, setOpts.Attributes); err != nil {
+		return nil, fmt.Errorf("MakeBucket(%s, region=%q, objectLocking=%t): %w",
+			setOpts.BucketName, dsm.options.BucketRegion, dsm.options.BucketObjectLocking, err)
 	}
 
 	data := setOpts.Data
 
 	// encrypt data if crypto service is provided
 	if dsm.options.CryptoService != nil {
-		encryptedData, err := dsm.options.CryptoService.Encrypt(data)
+		encryptedData, err := dsm.encrypt(data)
 		if err != nil {
-			return fmt.Errorf("'%s' failed to encrypt data: %w", dsm.String(), err)
+			return nil, fmt.Errorf("'%s' failed to encrypt data: %w", dsm.String(), err)
 		}
 		data = encryptedData
 	}
@@ -179,17 +350,35 @@ func (dsm *dataStoreMinio) Set(ctx context.Context, opts ...comby.DataStoreSetOp
 	// convert byte slice to io.Reader
 	reader := bytes.NewReader(data)
 	objectSize := int64(len(data))
+	record.Size = objectSize
 	opts2 := minio.PutObjectOptions{
 		ContentType: setOpts.ContentType,
 	}
-	_, err = dsm.minioClient.PutObject(ctx, setOpts.BucketName, setOpts.ObjectName, reader, objectSize, opts2)
+	applyObjectLock(setOpts.Attributes, &opts2)
+	if err = dsm.applyPublicObject(ctx, setOpts.BucketName, setOpts.ObjectName, setOpts.Attributes, &opts2); err != nil {
+		return nil, err
+	}
+	conditional := false
+	if header := conditionalHeader(setOpts.Attributes); header != nil {
+		ctx = withRequestHeader(ctx, header)
+		conditional = true
+	}
+	dsm.invalidateCache(setOpts.BucketName, setOpts.ObjectName)
+	uploadInfo, err := dsm.minioClient.PutObject(ctx, setOpts.BucketName, setOpts.ObjectName, reader, objectSize, opts2)
 	if err != nil {
-		return fmt.Errorf("PutObject(%s/%s, size=%d): %w", setOpts.BucketName, setOpts.ObjectName, objectSize, err)
+		if conditional && isConditionalWriteFailed(err) {
+			err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		return nil, fmt.Errorf("PutObject(%s/%s, size=%d): %w", setOpts.BucketName, setOpts.ObjectName, objectSize, err)
 	}
-	return nil
+	record.ETag, record.VersionID = uploadInfo.ETag, uploadInfo.VersionID
+	return &SetResult{
+		ETag:      uploadInfo.ETag,
+		VersionID: uploadInfo.VersionID,
+	}, nil
 }
 
-func (dsm *dataStoreMinio) Copy(ctx context.Context, opts ...comby.DataStoreCopyOption) error {
+func (dsm *dataStoreMinio) Copy(ctx context.Context, opts ...comby.DataStoreCopyOption) (err error) {
 	copyOpts := comby.DataStoreCopyOptions{
 		Attributes: comby.NewAttributes(),
 	}
@@ -198,54 +387,63 @@ func (dsm *dataStoreMinio) Copy(ctx context.Context, opts ...comby.DataStoreCopy
 			return err
 		}
 	}
-	var err error
-	// ensure destination bucket exists (see Set() for rationale on error handling)
-	bucketExists, err := dsm.minioClient.BucketExists(ctx, copyOpts.DstBucketName)
-	if err != nil {
-		bucketExists = false
-	}
-	if !bucketExists {
-		isBucketPublic := false
-		if _val := copyOpts.Attributes.Get(comby.DATA_STORE_ATTRIBUTE_IS_PUBLIC); _val != nil {
-			switch val := _val.(type) {
-			case bool:
-				isBucketPublic = val
-			}
-		}
-		makeBucketOptions := minio.MakeBucketOptions{
-			Region:        dsm.options.BucketRegion,
-			ObjectLocking: dsm.options.BucketObjectLocking,
-		}
-		if err = dsm.createBucket(ctx, copyOpts.DstBucketName, isBucketPublic, makeBucketOptions); err != nil {
-			return err
-		}
+	ctx, op := dsm.startOperation(ctx, "Copy", copyOpts.DstBucketName, copyOpts.DstObjectName)
+	op.span.SetAttributes(
+		attribute.String(spanAttrBucket+".source", copyOpts.SrcBucketName),
+		attribute.String(spanAttrObject+".source", copyOpts.SrcObjectName),
+	)
+	defer func() { op.end(err) }()
+	record := dsm.newAuditRecord(ctx, "Copy", copyOpts.DstBucketName, copyOpts.DstObjectName)
+	record.SourceBucket, record.SourceKey = copyOpts.SrcBucketName, copyOpts.SrcObjectName
+	defer func() { dsm.audit(ctx, record, err) }()
+
+	// ensure destination bucket exists
+	if err = dsm.ensureBucket(ctx, copyOpts.DstBucketName, copyOpts.Attributes); err != nil {
+		return err
 	}
 	// source options
 	srcOpts := minio.CopySrcOptions{
 		Bucket: copyOpts.SrcBucketName,
 		Object: copyOpts.SrcObjectName,
 	}
+	srcOpts.MatchETag, _ = attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_MATCH_ETAG)
+	srcOpts.NoMatchETag, _ = attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_NONE_MATCH_ETAG)
+	srcOpts.MatchModifiedSince, _ = attributeValue[time.Time](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_MODIFIED_SINCE)
+	srcOpts.MatchUnmodifiedSince, _ = attributeValue[time.Time](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_UNMODIFIED_SINCE)
+
 	// destination options
 	dstOpts := minio.CopyDestOptions{
 		Bucket: copyOpts.DstBucketName,
 		Object: copyOpts.DstObjectName,
 	}
-	// copy server-side to new destination
-	_, err = dsm.minioClient.CopyObject(ctx, dstOpts, srcOpts)
-	if err != nil {
-		return err
+	if directive, _ := attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_METADATA_DIRECTIVE); directive == METADATA_DIRECTIVE_REPLACE {
+		userMetadata, _ := attributeValue[map[string]string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_USER_METADATA)
+		contentType, _ := attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_CONTENT_TYPE)
+		dstOpts.ReplaceMetadata = true
+		dstOpts.UserMetadata = copyUserMetadata(userMetadata, contentType)
 	}
-	return nil
+	if tags, ok := attributeValue[map[string]string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_TAGS); ok {
+		dstOpts.ReplaceTags = true
+		dstOpts.UserTags = tags
+	}
+
+	uploadInfo, err := dsm.copyObject(ctx, dstOpts, srcOpts)
+	record.Size, record.ETag, record.VersionID = uploadInfo.Size, uploadInfo.ETag, uploadInfo.VersionID
+	return err
 }
 
-func (dsm *dataStoreMinio) List(ctx context.Context, opts ...comby.DataStoreListOption) ([]*comby.DataModel, int64, error) {
+func (dsm *dataStoreMinio) List(ctx context.Context, opts ...comby.DataStoreListOption) (items []*comby.DataModel, total int64, err error) {
 	listOpts := comby.DataStoreListOptions{}
 	for _, opt := range opts {
 		if _, err := opt(&listOpts); err != nil {
 			return nil, 0, err
 		}
 	}
-	var items []*comby.DataModel
+	ctx, op := dsm.startOperation(ctx, "List", "", "")
+	defer func() {
+		op.span.SetAttributes(attribute.Int64(spanAttrObjects, total))
+		op.end(err)
+	}()
 	if dsm.minioClient != nil {
 		// TODO: naive implementation, should be optimized
 		buckets, err := dsm.minioClient.ListBuckets(ctx)
@@ -268,27 +466,39 @@ func (dsm *dataStoreMinio) List(ctx context.Context, opts ...comby.DataStoreList
 			}
 		}
 	}
-	var total int64 = int64(len(items))
-	return items, total, nil
+	return items, int64(len(items)), nil
 }
 
-func (dsm *dataStoreMinio) Delete(ctx context.Context, opts ...comby.DataStoreDeleteOption) error {
+func (dsm *dataStoreMinio) Delete(ctx context.Context, opts ...comby.DataStoreDeleteOption) (err error) {
 	deleteOpts := comby.DataStoreDeleteOptions{}
 	for _, opt := range opts {
 		if _, err := opt(&deleteOpts); err != nil {
 			return err
 		}
 	}
+	ctx, op := dsm.startOperation(ctx, "Delete", deleteOpts.BucketName, deleteOpts.ObjectName)
+	defer func() { op.end(err) }()
+	record := dsm.newAuditRecord(ctx, "Delete", deleteOpts.BucketName, deleteOpts.ObjectName)
+	defer func() { dsm.audit(ctx, record, err) }()
 	opts2 := minio.RemoveObjectOptions{}
-	return dsm.minioClient.RemoveObject(ctx, deleteOpts.BucketName, deleteOpts.ObjectName, opts2)
+	dsm.invalidateCache(deleteOpts.BucketName, deleteOpts.ObjectName)
+	return lockError(dsm.minioClient.RemoveObject(ctx, deleteOpts.BucketName, deleteOpts.ObjectName, opts2))
 }
 
 func (dsm *dataStoreMinio) Total(ctx context.Context) int64 {
+	ctx, op := dsm.startOperation(ctx, "Total", "", "")
 	total := int64(0)
+	var err error
+	defer func() {
+		op.span.SetAttributes(attribute.Int64(spanAttrObjects, total))
+		op.end(err)
+	}()
 	if dsm.minioClient != nil {
 		// TODO: naive implementation, should be optimized
-		buckets, err := dsm.minioClient.ListBuckets(ctx)
+		var buckets []minio.BucketInfo
+		buckets, err = dsm.minioClient.ListBuckets(ctx)
 		if err != nil {
+			dsm.logger().Warn("failed to count objects", "store", dsm.String(), "error", err)
 			return 0
 		}
 		for _, bucket := range buckets {
@@ -298,7 +508,7 @@ func (dsm *dataStoreMinio) Total(ctx context.Context) int64 {
 			})
 			for object := range objectCh {
 				if object.Err != nil {
-					slog.Warn("minio total: skipping object with error", "bucket", bucket.Name, "err", object.Err)
+					dsm.logger().Warn("skipping object with error in total", "store", dsm.String(), "bucket", bucket.Name, "error", object.Err)
 					continue
 				}
 				total += 1
@@ -310,6 +520,11 @@ func (dsm *dataStoreMinio) Total(ctx context.Context) int64 {
 
 func (dsm *dataStoreMinio) Close(ctx context.Context) error {
 	// Minio client doesn't require explicit close
+	if dsm.stopHealthCheck != nil {
+		dsm.stopHealthCheck()
+		dsm.stopHealthCheck = nil
+	}
+	dsm.metrics().unregister(dsm)
 	return nil
 }
 
@@ -321,49 +536,175 @@ func (dsm *dataStoreMinio) String() string {
 	return "minio://" + dsm.Endpoint
 }
 
+// logger returns the configured logger or the default logger.
+func (dsm *dataStoreMinio) logger() *slog.Logger {
+	if logger, ok := attributeValue[*slog.Logger](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_LOGGER); ok && logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+// ensureBucket creates the bucket if it does not exist yet. The attributes
+// decide whether a newly created bucket is public.
+func (dsm *dataStoreMinio) ensureBucket(ctx context.Context, bucketName string, attributes *comby.Attributes) error {
+	// Note: some S3-compatible providers (e.g., Hetzner Object Storage) return
+	// unexpected errors like NoSuchKey instead of a clean 404 for non-existent
+	// buckets. We treat any BucketExists error as "bucket does not exist" and
+	// attempt to create it.
+	bucketExists, err := dsm.minioClient.BucketExists(ctx, bucketName)
+	if err != nil {
+		bucketExists = false
+	}
+	if bucketExists {
+		return nil
+	}
+	isBucketPublic := false
+	if attributes != nil {
+		if _val := attributes.Get(comby.DATA_STORE_ATTRIBUTE_IS_PUBLIC); _val != nil {
+			switch val := _val.(type) {
+			case bool:
+				isBucketPublic = val
+			}
+		}
+	}
+	makeBucketOptions := minio.MakeBucketOptions{
+		Region:        dsm.options.BucketRegion,
+		ObjectLocking: dsm.options.BucketObjectLocking,
+	}
+	return dsm.createBucket(ctx, bucketName, isBucketPublic, makeBucketOptions)
+}
+
+// copyObject copies server-side from srcOpts to dstOpts and returns the ETag
+// and size of the copy. A single CopyObject request is limited to 5 GiB,
+// larger objects are copied in parts.
+func (dsm *dataStoreMinio) copyObject(ctx context.Context, dstOpts minio.CopyDestOptions, srcOpts minio.CopySrcOptions) (minio.UploadInfo, error) {
+	dsm.invalidateCache(dstOpts.Bucket, dstOpts.Object)
+	statOpts := minio.StatObjectOptions{VersionID: srcOpts.VersionID}
+	objectInfo, err := dsm.minioClient.StatObject(ctx, srcOpts.Bucket, srcOpts.Object, statOpts)
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("StatObject(%s/%s): %w", srcOpts.Bucket, srcOpts.Object, err)
+	}
+	if objectInfo.Size <= maxCopyObjectSize {
+		// copy server-side to new destination
+		uploadInfo, err := dsm.minioClient.CopyObject(ctx, dstOpts, srcOpts)
+		if isPreconditionFailed(err) {
+			return uploadInfo, fmt.Errorf("CopyObject(%s/%s): %w", srcOpts.Bucket, srcOpts.Object, ErrPreconditionFailed)
+		}
+		if err != nil {
+			return uploadInfo, err
+		}
+		uploadInfo.Size = objectInfo.Size
+		return uploadInfo, nil
+	}
+
+	// multipart copy does not evaluate the source conditions, check them here
+	if !sourceMatches(objectInfo, srcOpts) {
+		return minio.UploadInfo{}, fmt.Errorf("ComposeObject(%s/%s): %w", srcOpts.Bucket, srcOpts.Object, ErrPreconditionFailed)
+	}
+	// multipart copy does not carry over the content type, keep it explicitly
+	if !dstOpts.ReplaceMetadata {
+		dstOpts.ReplaceMetadata = true
+		dstOpts.UserMetadata = copyUserMetadata(objectInfo.UserMetadata, objectInfo.ContentType)
+	}
+	// neither are the tags, which are not part of the stat response
+	if !dstOpts.ReplaceTags && objectInfo.UserTagCount > 0 {
+		tagOpts := minio.GetObjectTaggingOptions{VersionID: srcOpts.VersionID}
+		objectTags, err := dsm.minioClient.GetObjectTagging(ctx, srcOpts.Bucket, srcOpts.Object, tagOpts)
+		if err != nil {
+			return minio.UploadInfo{}, fmt.Errorf("GetObjectTagging(%s/%s): %w", srcOpts.Bucket, srcOpts.Object, err)
+		}
+		dstOpts.ReplaceTags = true
+		dstOpts.UserTags = objectTags.ToMap()
+	}
+	uploadInfo, err := dsm.minioClient.ComposeObject(ctx, dstOpts, srcOpts)
+	if err != nil {
+		return uploadInfo, fmt.Errorf("ComposeObject(%s/%s, size=%d): %w", srcOpts.Bucket, srcOpts.Object, objectInfo.Size, err)
+	}
+	uploadInfo.Size = objectInfo.Size
+	return uploadInfo, nil
+}
+
+// maxCopyObjectSize is the largest object a single CopyObject request can
+// copy, larger objects are copied in parts with ComposeObject.
+const maxCopyObjectSize = 1024 * 1024 * 1024 * 5
+
+// copyUserMetadata returns a copy of the user metadata including the content
+// type, as expected by CopyDestOptions when metadata is replaced.
+func copyUserMetadata(userMetadata map[string]string, contentType string) map[string]string {
+	result := make(map[string]string, len(userMetadata)+1)
+	for k, v := range userMetadata {
+		result[k] = v
+	}
+	if contentType != "" {
+		result["Content-Type"] = contentType
+	}
+	return result
+}
+
+// sourceMatches evaluates the conditions of srcOpts against the source object.
+func sourceMatches(objectInfo minio.ObjectInfo, srcOpts minio.CopySrcOptions) bool {
+	if srcOpts.MatchETag != "" && strings.Trim(srcOpts.MatchETag, `"`) != strings.Trim(objectInfo.ETag, `"`) {
+		return false
+	}
+	if srcOpts.NoMatchETag != "" && strings.Trim(srcOpts.NoMatchETag, `"`) == strings.Trim(objectInfo.ETag, `"`) {
+		return false
+	}
+	if !srcOpts.MatchModifiedSince.IsZero() && !objectInfo.LastModified.After(srcOpts.MatchModifiedSince) {
+		return false
+	}
+	if !srcOpts.MatchUnmodifiedSince.IsZero() && objectInfo.LastModified.After(srcOpts.MatchUnmodifiedSince) {
+		return false
+	}
+	return true
+}
+
 func (dsm *dataStoreMinio) createBucket(ctx context.Context, bucketName string, public bool, makeBucketOptions minio.MakeBucketOptions) error {
 	var err error
 	err = dsm.minioClient.MakeBucket(ctx, bucketName, makeBucketOptions)
 	if err != nil {
 		return err
 	}
-	if public {
-		policy := fmt.Sprintf(`{
-				"Statement": [
-				 {
-				  "Action": [
-				   "s3:GetObject"
-				  ],
-				  "Effect": "Allow",
-				  "Principal": {
-				   "AWS": [
-					"*"
-				   ]
-				  },
-				  "Resource": [
-				   "arn:aws:s3:::%s/*"
-				  ]
-				 }
-				],
-				"Version": "2012-10-17"
-			   }`, bucketName)
-		err = dsm.minioClient.SetBucketPolicy(ctx, bucketName, policy)
+	dsm.metrics().bucketCreated(dsm)
+	dsm.logger().Info("bucket created", "store", dsm.String(), "bucket", bucketName,
+		"region", makeBucketOptions.Region, "objectLocking", makeBucketOptions.ObjectLocking)
+	dsm.publicBuckets.Delete(bucketName)
+	if template := dsm.bucketPolicy(public); template != nil {
+		policy, err := template(bucketName)
+		if err != nil {
+			return err
+		}
+		if policy != nil {
+			err = dsm.SetBucketPolicy(ctx, bucketName, policy)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// enable versioning
+	if versioning, _ := attributeValue[bool](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_VERSIONING); versioning {
+		err = dsm.minioClient.EnableVersioning(ctx, bucketName)
 		if err != nil {
 			return err
 		}
 	}
 
-	// enable versioning?
-	/*
-		err := dsm.minioClient.EnableVersioning(ctx, bucketName)
+	// attach lifecycle rules
+	if rules, _ := attributeValue[[]LifecycleRule](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_LIFECYCLE_RULES); len(rules) > 0 {
+		err = dsm.SetLifecycle(ctx, bucketName, rules...)
 		if err != nil {
 			return err
 		}
-	*/
+	}
 	return nil
 }
 
-func (dsm *dataStoreMinio) Info(ctx context.Context) (*comby.DataStoreInfoModel, error) {
+func (dsm *dataStoreMinio) Info(ctx context.Context) (info *comby.DataStoreInfoModel, err error) {
+	ctx, op := dsm.startOperation(ctx, "Info", "", "")
+	defer func() {
+		op.span.SetAttributes(attribute.Int64(spanAttrObjects, dsm.dataStoreInfoModel.NumObjects))
+		op.end(err)
+	}()
 
 	// reset
 	dsm.dataStoreInfoModel.LastUpdateTime = 0
@@ -383,7 +724,7 @@ func (dsm *dataStoreMinio) Info(ctx context.Context) (*comby.DataStoreInfoModel,
 		})
 		for object := range objectCh {
 			if object.Err != nil {
-				slog.Warn("minio info: skipping object with error", "bucket", bucket.Name, "err", object.Err)
+				dsm.logger().Warn("skipping object with error in info", "store", dsm.String(), "bucket", bucket.Name, "error", object.Err)
 				continue
 			}
 			dsm.dataStoreInfoModel.NumObjects += 1
@@ -393,45 +734,14 @@ func (dsm *dataStoreMinio) Info(ctx context.Context) (*comby.DataStoreInfoModel,
 			}
 		}
 	}
+	snapshot := *dsm.dataStoreInfoModel
+	dsm.lastInfo.Store(&snapshot)
 	return dsm.dataStoreInfoModel, nil
 }
 
-func (dsm *dataStoreMinio) Reset(ctx context.Context) error {
-	if dsm.minioClient != nil {
-		buckets, err := dsm.minioClient.ListBuckets(ctx)
-		if err != nil {
-			return err
-		}
-
-		var errs []error
-		for _, bucket := range buckets {
-			// First, remove all objects and their versions in the bucket
-			objectCh := dsm.minioClient.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{
-				Recursive:    true,
-				WithVersions: true,
-			})
-			for object := range objectCh {
-				if object.Err != nil {
-					errs = append(errs, fmt.Errorf("failed to list object in bucket %s: %w", bucket.Name, object.Err))
-					continue
-				}
-				removeOpts := minio.RemoveObjectOptions{
-					VersionID: object.VersionID,
-				}
-				if err := dsm.minioClient.RemoveObject(ctx, bucket.Name, object.Key, removeOpts); err != nil {
-					errs = append(errs, fmt.Errorf("failed to remove object %s/%s: %w", bucket.Name, object.Key, err))
-				}
-			}
-
-			// Then, remove the bucket itself
-			if err := dsm.minioClient.RemoveBucket(ctx, bucket.Name); err != nil {
-				errs = append(errs, fmt.Errorf("failed to remove bucket %s: %w", bucket.Name, err))
-			}
-		}
-
-		if len(errs) > 0 {
-			return fmt.Errorf("reset completed with %d errors: %v", len(errs), errs)
-		}
-	}
-	return nil
+func (dsm *dataStoreMinio) Reset(ctx context.Context) (err error) {
+	ctx, op := dsm.startOperation(ctx, "Reset", "", "")
+	defer func() { op.end(err) }()
+	_, err = dsm.ResetWithResult(ctx)
+	return err
 }
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// User metadata of lease objects.
+const (
+	leaseMetaOwner   = "Lease-Owner"
+	leaseMetaToken   = "Lease-Token"
+	leaseMetaExpires = "Lease-Expires"
+)
+
+// leaseAttempts bounds the retries of AcquireLease when contenders race.
+const leaseAttempts = 3
+
+// Lease is a lock on an object name held until it expires, is released or
+// is taken over after expiry. Expiry is decided by the clocks of the
+// contenders, which must be roughly in sync.
+type Lease struct {
+	BucketName string
+	ObjectName string
+	Owner      string
+	// Token increases with every acquisition of the lease. Pass it to the
+	// protected resource so it can reject requests of former holders.
+	Token int64
+
+	// expiry and ETag of the lease object, guarded by mu once the lease is
+	// returned
+	mu        sync.Mutex
+	expiresAt time.Time
+	etag      string
+	dsm       *dataStoreMinio
+}
+
+// AcquireLease acquires the lease stored as the given object for owner and
+// ttl. It fails with ErrLeaseHeld if another owner holds an unexpired lease.
+// An expired lease is taken over with the next fencing token.
+func (dsm *dataStoreMinio) AcquireLease(ctx context.Context, bucketName, objectName, owner string, ttl time.Duration) (*Lease, error) {
+	if err := dsm.ensureBucket(ctx, bucketName, nil); err != nil {
+		return nil, fmt.Errorf("MakeBucket(%s): %w", bucketName, err)
+	}
+	dsm.leaseBuckets.Store(bucketName, true)
+	for attempt := 0; attempt < leaseAttempts; attempt++ {
+		lease := &Lease{
+			BucketName: bucketName,
+			ObjectName: objectName,
+			Owner:      owner,
+			Token:      1,
+			expiresAt:  time.Now().Add(ttl),
+			dsm:        dsm,
+		}
+
+		// create the lease if nobody ever held it
+		err := lease.write(ctx, lease.expiresAt, http.Header{"If-None-Match": {"*"}})
+		if err == nil {
+			return lease, nil
+		}
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return nil, err
+		}
+
+		// take over an expired lease
+		objectInfo, err := dsm.minioClient.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				continue
+			}
+			return nil, fmt.Errorf("StatObject(%s/%s): %w", bucketName, objectName, err)
+		}
+		current := newLease(objectInfo)
+		if time.Now().Before(current.expiresAt) {
+			return nil, fmt.Errorf("%w: %s/%s by %q until %s", ErrLeaseHeld, bucketName, objectName, current.Owner, current.expiresAt.Format(time.RFC3339))
+		}
+		lease.Token = current.Token + 1
+		err = lease.write(ctx, lease.expiresAt, http.Header{"If-Match": {quoteETag(objectInfo.ETag)}})
+		if err == nil {
+			dsm.logger().Debug("expired lease taken over", "bucket", bucketName, "object", objectName,
+				"owner", owner, "previousOwner", current.Owner, "token", lease.Token)
+			return lease, nil
+		}
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return nil, err
+		}
+		// another contender was faster, look again
+	}
+	return nil, fmt.Errorf("%w: %s/%s", ErrLeaseHeld, bucketName, objectName)
+}
+
+// ExpiresAt returns the time the lease expires unless it is renewed.
+func (l *Lease) ExpiresAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiresAt
+}
+
+// Renew extends the lease by ttl from now. It fails with ErrLeaseLost if the
+// lease was taken over.
+func (l *Lease) Renew(ctx context.Context, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.write(ctx, time.Now().Add(ttl), http.Header{"If-Match": {quoteETag(l.etag)}}); err != nil {
+		return l.lostError(err)
+	}
+	return nil
+}
+
+// Release expires the lease so the next contender can acquire it. The lease
+// object is kept to continue its fencing tokens.
+func (l *Lease) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.write(ctx, time.Now(), http.Header{"If-Match": {quoteETag(l.etag)}}); err != nil {
+		return l.lostError(err)
+	}
+	return nil
+}
+
+func (l *Lease) lostError(err error) error {
+	if errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("%w: %s/%s token %d", ErrLeaseLost, l.BucketName, l.ObjectName, l.Token)
+	}
+	return err
+}
+
+// leaseBody is the content of a lease object. It differs for every write,
+// so the ETag used to detect competing writes changes as well.
+type leaseBody struct {
+	Owner     string    `json:"owner"`
+	Token     int64     `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Nonce     string    `json:"nonce"`
+}
+
+// write writes the lease object with the given expiry under the given
+// preconditions and updates expiry and ETag of the lease on success.
+func (l *Lease) write(ctx context.Context, expiresAt time.Time, header http.Header) error {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	body, err := json.Marshal(&leaseBody{Owner: l.Owner, Token: l.Token, ExpiresAt: expiresAt.UTC(), Nonce: hex.EncodeToString(nonce)})
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{
+		ContentType: "application/json",
+		UserMetadata: map[string]string{
+			leaseMetaOwner:   l.Owner,
+			leaseMetaToken:   strconv.FormatInt(l.Token, 10),
+			leaseMetaExpires: expiresAt.UTC().Format(time.RFC3339Nano),
+		},
+	}
+	l.dsm.invalidateCache(l.BucketName, l.ObjectName)
+	uploadInfo, err := l.dsm.minioClient.PutObject(withRequestHeader(ctx, header), l.BucketName, l.ObjectName, bytes.NewReader(body), int64(len(body)), opts)
+	if err != nil {
+		if isConditionalWriteFailed(err) {
+			return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		return fmt.Errorf("PutObject(%s/%s): %w", l.BucketName, l.ObjectName, err)
+	}
+	l.expiresAt = expiresAt
+	l.etag = uploadInfo.ETag
+	return nil
+}
+
+// newLease reads owner, token and expiry of a lease from the metadata of its
+// object. Unreadable expiry times are treated as expired.
+func newLease(objectInfo minio.ObjectInfo) *Lease {
+	lease := &Lease{
+		Owner: objectInfo.UserMetadata[leaseMetaOwner],
+		etag:  objectInfo.ETag,
+	}
+	lease.Token, _ = strconv.ParseInt(objectInfo.UserMetadata[leaseMetaToken], 10, 64)
+	lease.expiresAt, _ = time.Parse(time.RFC3339Nano, objectInfo.UserMetadata[leaseMetaExpires])
+	return lease
+}
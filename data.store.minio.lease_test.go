@@ -0,0 +1,146 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+)
+
+func TestDataStoreLease(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	_, endpoint := newFakeS3(t)
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// acquire a new lease
+	lease, err := dataStore.AcquireLease(ctx, "leases", "jobs/import", "worker-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Token != 1 {
+		t.Fatalf("wrong token: %d", lease.Token)
+	}
+
+	// a held lease cannot be acquired
+	if _, err := dataStore.AcquireLease(ctx, "leases", "jobs/import", "worker-2", time.Minute); !errors.Is(err, store.ErrLeaseHeld) {
+		t.Fatalf("expected held lease, got %v", err)
+	}
+
+	// renew while the expiry is read concurrently, then expire by renewing
+	// with a negative ttl
+	expiresAt := lease.ExpiresAt()
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for i := 0; i < 100; i++ {
+			_ = lease.ExpiresAt()
+		}
+	}()
+	if err := lease.Renew(ctx, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	<-readDone
+	if !lease.ExpiresAt().After(expiresAt) {
+		t.Fatalf("expected later expiry than %s, got %s", expiresAt, lease.ExpiresAt())
+	}
+	if err := lease.Renew(ctx, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// a stale lease is taken over with the next token
+	takeover, err := dataStore.AcquireLease(ctx, "leases", "jobs/import", "worker-2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if takeover.Token != 2 {
+		t.Fatalf("wrong token: %d", takeover.Token)
+	}
+
+	// the former holder lost the lease
+	if err := lease.Renew(ctx, time.Minute); !errors.Is(err, store.ErrLeaseLost) {
+		t.Fatalf("expected lost lease, got %v", err)
+	}
+	if err := lease.Release(ctx); !errors.Is(err, store.ErrLeaseLost) {
+		t.Fatalf("expected lost lease, got %v", err)
+	}
+
+	// a released lease can be acquired again, tokens continue
+	if err := takeover.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	next, err := dataStore.AcquireLease(ctx, "leases", "jobs/import", "worker-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Token != 3 {
+		t.Fatalf("wrong token: %d", next.Token)
+	}
+}
+
+func TestDataStoreLeaseContenders(t *testing.T) {
+	ctx := context.Background()
+	_, endpoint := newFakeS3(t)
+
+	// contenders with their own store each
+	const numContenders = 8
+	const numRounds = 5
+	var holders atomic.Int32
+	var mu sync.Mutex
+	tokens := map[int64]string{}
+	var wg sync.WaitGroup
+	for i := 0; i < numContenders; i++ {
+		dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+		if err := dataStore.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		owner := fmt.Sprintf("worker-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for acquired := 0; acquired < numRounds; {
+				lease, err := dataStore.AcquireLease(ctx, "leases", "singleton", owner, time.Minute)
+				if errors.Is(err, store.ErrLeaseHeld) {
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				acquired++
+
+				// only one holder at a time, tokens are unique
+				if n := holders.Add(1); n != 1 {
+					t.Errorf("%d holders", n)
+				}
+				mu.Lock()
+				if other, ok := tokens[lease.Token]; ok {
+					t.Errorf("token %d granted to %s and %s", lease.Token, other, owner)
+				}
+				tokens[lease.Token] = owner
+				mu.Unlock()
+				holders.Add(-1)
+
+				if err := lease.Release(ctx); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(tokens) != numContenders*numRounds {
+		t.Fatalf("wrong number of tokens: %d", len(tokens))
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// LifecycleRule is a simplified bucket lifecycle rule. A rule applies to the
+// objects matching Prefix and all Tags, zero values disable an action.
+type LifecycleRule struct {
+	ID     string
+	Prefix string
+	Tags   map[string]string
+	// ExpirationDays removes objects this many days after creation.
+	ExpirationDays int
+	// TransitionDays moves objects this many days after creation to
+	// TransitionStorageClass, e.g. a remote tier configured in MinIO.
+	TransitionDays         int
+	TransitionStorageClass string
+	// NoncurrentVersionExpirationDays removes versions this many days after
+	// they became noncurrent (versioned buckets only).
+	NoncurrentVersionExpirationDays int
+	// AbortIncompleteMultipartUploadDays aborts multipart uploads not
+	// completed this many days after they were started.
+	AbortIncompleteMultipartUploadDays int
+}
+
+// DataStoreOptionWithLifecycleRules attaches the lifecycle rules to every
+// bucket created by the store.
+func DataStoreOptionWithLifecycleRules(rules ...LifecycleRule) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_LIFECYCLE_RULES, rules)
+}
+
+// GetLifecycle returns the lifecycle rules of a bucket. A bucket without
+// lifecycle configuration has no rules.
+func (dsm *dataStoreMinio) GetLifecycle(ctx context.Context, bucketName string) ([]LifecycleRule, error) {
+	config, err := dsm.minioClient.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("GetBucketLifecycle(%s): %w", bucketName, err)
+	}
+	rules := make([]LifecycleRule, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		rules = append(rules, newLifecycleRule(rule))
+	}
+	return rules, nil
+}
+
+// SetLifecycle replaces the lifecycle rules of a bucket. Without rules the
+// lifecycle configuration is removed.
+func (dsm *dataStoreMinio) SetLifecycle(ctx context.Context, bucketName string, rules ...LifecycleRule) error {
+	if err := dsm.minioClient.SetBucketLifecycle(ctx, bucketName, newLifecycleConfiguration(rules)); err != nil {
+		return fmt.Errorf("SetBucketLifecycle(%s, rules=%d): %w", bucketName, len(rules), err)
+	}
+	return nil
+}
+
+func newLifecycleConfiguration(rules []LifecycleRule) *lifecycle.Configuration {
+	config := lifecycle.NewConfiguration()
+	for i, rule := range rules {
+		id := rule.ID
+		if id == "" {
+			id = fmt.Sprintf("rule-%d", i+1)
+		}
+		lcRule := lifecycle.Rule{
+			ID:     id,
+			Status: "Enabled",
+		}
+
+		// filter: prefix, single tag or both combined
+		var tags []lifecycle.Tag
+		for _, key := range sortedKeys(rule.Tags) {
+			tags = append(tags, lifecycle.Tag{Key: key, Value: rule.Tags[key]})
+		}
+		switch {
+		case len(tags) == 0:
+			lcRule.RuleFilter.Prefix = rule.Prefix
+		case len(tags) == 1 && rule.Prefix == "":
+			lcRule.RuleFilter.Tag = tags[0]
+		default:
+			lcRule.RuleFilter.And = lifecycle.And{Prefix: rule.Prefix, Tags: tags}
+		}
+
+		if rule.ExpirationDays > 0 {
+			lcRule.Expiration.Days = lifecycle.ExpirationDays(rule.ExpirationDays)
+		}
+		if rule.TransitionDays > 0 {
+			lcRule.Transition.Days = lifecycle.ExpirationDays(rule.TransitionDays)
+			lcRule.Transition.StorageClass = rule.TransitionStorageClass
+		}
+		if rule.NoncurrentVersionExpirationDays > 0 {
+			lcRule.NoncurrentVersionExpiration.NoncurrentDays = lifecycle.ExpirationDays(rule.NoncurrentVersionExpirationDays)
+		}
+		if rule.AbortIncompleteMultipartUploadDays > 0 {
+			lcRule.AbortIncompleteMultipartUpload.DaysAfterInitiation = lifecycle.ExpirationDays(rule.AbortIncompleteMultipartUploadDays)
+		}
+		config.Rules = append(config.Rules, lcRule)
+	}
+	return config
+}
+
+func newLifecycleRule(lcRule lifecycle.Rule) LifecycleRule {
+	rule := LifecycleRule{
+		ID:                                 lcRule.ID,
+		Prefix:                             lcRule.RuleFilter.Prefix,
+		ExpirationDays:                     int(lcRule.Expiration.Days),
+		TransitionDays:                     int(lcRule.Transition.Days),
+		TransitionStorageClass:             lcRule.Transition.StorageClass,
+		NoncurrentVersionExpirationDays:    int(lcRule.NoncurrentVersionExpiration.NoncurrentDays),
+		AbortIncompleteMultipartUploadDays: int(lcRule.AbortIncompleteMultipartUpload.DaysAfterInitiation),
+	}
+	if rule.Prefix == "" {
+		rule.Prefix = lcRule.RuleFilter.And.Prefix
+	}
+	if rule.Prefix == "" {
+		rule.Prefix = lcRule.Prefix
+	}
+	tags := lcRule.RuleFilter.And.Tags
+	if !lcRule.RuleFilter.Tag.IsEmpty() {
+		tags = append(tags, lcRule.RuleFilter.Tag)
+	}
+	if len(tags) > 0 {
+		rule.Tags = make(map[string]string, len(tags))
+		for _, tag := range tags {
+			rule.Tags[tag.Key] = tag.Value
+		}
+	}
+	return rule
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreLifecycle(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store with lifecycle rules
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithVersioning(true),
+		store.DataStoreOptionWithLifecycleRules(
+			store.LifecycleRule{ID: "tmp", Prefix: "tmp/", ExpirationDays: 1},
+			store.LifecycleRule{ID: "uploads", AbortIncompleteMultipartUploadDays: 2},
+			store.LifecycleRule{ID: "versions", Tags: map[string]string{"kind": "draft"}, NoncurrentVersionExpirationDays: 7},
+		),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set creates the bucket with its rules
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("lifecycle-bucket"),
+		comby.DataStoreSetOptionWithObjectName("tmp/a"),
+		comby.DataStoreSetOptionWithData([]byte("a")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := dataStore.GetLifecycle(ctx, "lifecycle-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("wrong number of rules: %d", len(rules))
+	}
+	for _, rule := range rules {
+		switch rule.ID {
+		case "tmp":
+			if rule.Prefix != "tmp/" || rule.ExpirationDays != 1 {
+				t.Fatalf("wrong rule: %+v", rule)
+			}
+		case "uploads":
+			if rule.AbortIncompleteMultipartUploadDays != 2 {
+				t.Fatalf("wrong rule: %+v", rule)
+			}
+		case "versions":
+			if rule.Tags["kind"] != "draft" || rule.NoncurrentVersionExpirationDays != 7 {
+				t.Fatalf("wrong rule: %+v", rule)
+			}
+		default:
+			t.Fatalf("unexpected rule: %+v", rule)
+		}
+	}
+
+	// replace the rules
+	if err := dataStore.SetLifecycle(ctx, "lifecycle-bucket",
+		store.LifecycleRule{ID: "logs", Prefix: "logs/", Tags: map[string]string{"kind": "log"}, ExpirationDays: 30},
+	); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = dataStore.GetLifecycle(ctx, "lifecycle-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Prefix != "logs/" || rules[0].Tags["kind"] != "log" || rules[0].ExpirationDays != 30 {
+		t.Fatalf("wrong rules: %+v", rules)
+	}
+
+	// remove the rules
+	if err := dataStore.SetLifecycle(ctx, "lifecycle-bucket"); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = dataStore.GetLifecycle(ctx, "lifecycle-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Fatalf("expected no rules: %+v", rules)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+// withObjectLocking creates buckets with object locking enabled.
+func withObjectLocking(opt *comby.DataStoreOptions) (*comby.DataStoreOptions, error) {
+	opt.BucketObjectLocking = true
+	return opt, nil
+}
+
+func TestDataStoreObjectLock(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store with locked buckets
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123", withObjectLocking)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set value under governance retention
+	retainUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("locked-bucket"),
+		comby.DataStoreSetOptionWithObjectName("invoice"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData([]byte("invoice")),
+		store.DataStoreSetOptionWithRetention(store.RETENTION_MODE_GOVERNANCE, retainUntil),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stat reports the retention
+	stat, err := dataStore.Stat(ctx,
+		comby.DataStoreGetOptionWithBucketName("locked-bucket"),
+		comby.DataStoreGetOptionWithObjectName("invoice"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Retention == nil || stat.Retention.Mode != store.RETENTION_MODE_GOVERNANCE {
+		t.Fatalf("wrong retention: %+v", stat.Retention)
+	}
+	if !stat.Retention.RetainUntil.Equal(retainUntil) {
+		t.Fatalf("wrong retain until: %v", stat.Retention.RetainUntil)
+	}
+
+	// Removing the locked version fails with a typed error
+	if err := dataStore.DeleteVersion(ctx, stat.VersionID,
+		comby.DataStoreDeleteOptionWithBucketName("locked-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("invoice"),
+	); !errors.Is(err, store.ErrObjectLocked) {
+		t.Fatalf("expected object locked error, got: %v", err)
+	}
+
+	// Place and release a legal hold
+	if err := dataStore.SetLegalHold(ctx, true,
+		comby.DataStoreGetOptionWithBucketName("locked-bucket"),
+		comby.DataStoreGetOptionWithObjectName("invoice"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := dataStore.Stat(ctx,
+		comby.DataStoreGetOptionWithBucketName("locked-bucket"),
+		comby.DataStoreGetOptionWithObjectName("invoice"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if !stat.LegalHold {
+			t.Fatal("legal hold should be set")
+		}
+	}
+	if err := dataStore.SetLegalHold(ctx, false,
+		comby.DataStoreGetOptionWithBucketName("locked-bucket"),
+		comby.DataStoreGetOptionWithObjectName("invoice"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove the governance retention
+	if err := dataStore.SetRetention(ctx, store.ObjectRetention{GovernanceBypass: true},
+		comby.DataStoreGetOptionWithBucketName("locked-bucket"),
+		comby.DataStoreGetOptionWithObjectName("invoice"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
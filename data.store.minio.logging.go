@@ -0,0 +1,46 @@
+package store
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// loggingTransport writes a debug log entry for every S3 request with its
+// outcome, duration and request IDs. Headers, query parameters and bodies
+// are not logged, they may hold credentials, signatures or object data.
+type loggingTransport struct {
+	base http.RoundTripper
+	dsm  *dataStoreMinio
+}
+
+// loggingTransport wraps the transport with debug logs of the requests.
+func (dsm *dataStoreMinio) loggingTransport(base http.RoundTripper) http.RoundTripper {
+	return &loggingTransport{base: base, dsm: dsm}
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := t.dsm.logger()
+	if !logger.Enabled(req.Context(), slog.LevelDebug) {
+		return t.base.RoundTrip(req)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	args := []any{
+		"store", t.dsm.String(),
+		"method", req.Method,
+		"host", req.URL.Host,
+		"path", req.URL.Path,
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		logger.DebugContext(req.Context(), "S3 request failed", append(args, "error", err)...)
+		return resp, err
+	}
+	logger.DebugContext(req.Context(), "S3 request", append(args,
+		"status", resp.StatusCode,
+		"requestID", resp.Header.Get("X-Amz-Request-Id"),
+		"hostID", resp.Header.Get("X-Amz-Id-2"),
+	)...)
+	return resp, nil
+}
@@ -0,0 +1,88 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+// syncBuffer is a buffer safe for concurrent log writes.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDataStoreLogging(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	_, endpoint := newFakeS3(t)
+	output := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithLogger(logger),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("log-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("top-secret-content")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if dataStore.Total(ctx) != 1 {
+		t.Fatal("wrong total")
+	}
+
+	// S3 requests and bucket creations are logged to the given logger
+	entries := make(map[string][]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		entry := make(map[string]any)
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := entry["msg"].(string)
+		entries[msg] = append(entries[msg], entry)
+	}
+	if len(entries["bucket created"]) != 1 || entries["bucket created"][0]["bucket"] != "log-bucket" {
+		t.Fatalf("expected bucket creation entry, got %v", entries["bucket created"])
+	}
+	requests := entries["S3 request"]
+	if len(requests) < 3 {
+		t.Fatalf("expected S3 request entries, got %d", len(requests))
+	}
+	for _, entry := range requests {
+		if entry["requestID"] == "" || entry["status"] == nil || entry["duration"] == nil || entry["store"] != "minio://"+endpoint {
+			t.Fatalf("incomplete entry %v", entry)
+		}
+	}
+
+	// neither secrets nor object contents are logged
+	for _, secret := range []string{"CHANGEME123", "top-secret-content", "Signature"} {
+		if strings.Contains(output.String(), secret) {
+			t.Fatalf("log contains %q", secret)
+		}
+	}
+}
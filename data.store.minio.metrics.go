@@ -0,0 +1,323 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Results of store operations as reported by Metrics.
+const (
+	METRICS_RESULT_SUCCESS             = "success"
+	METRICS_RESULT_NOT_FOUND           = "not_found"
+	METRICS_RESULT_PRECONDITION_FAILED = "precondition_failed"
+	METRICS_RESULT_ERROR               = "error"
+)
+
+// metricsNamespace prefixes the names of all metrics of the store.
+const metricsNamespace = "comby_minio"
+
+// Metrics collects Prometheus metrics of MinIO stores. It implements
+// prometheus.Collector and is registered by the application with its own
+// registry, nothing is registered globally. One Metrics may be shared by
+// several stores, their metrics are labeled with the endpoint.
+type Metrics struct {
+	operations      *prometheus.CounterVec
+	durations       *prometheus.HistogramVec
+	bytesRead       *prometheus.CounterVec
+	bytesWritten    *prometheus.CounterVec
+	crypto          *prometheus.HistogramVec
+	bucketCreations *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+
+	// collected from the stores on every scrape
+	connectionsOpen    *prometheus.Desc
+	connectionsMaxIdle *prometheus.Desc
+	requestsInFlight   *prometheus.Desc
+	infoBuckets        *prometheus.Desc
+	infoObjects        *prometheus.Desc
+	infoBytes          *prometheus.Desc
+
+	mu     sync.Mutex
+	stores map[*dataStoreMinio]struct{}
+}
+
+// NewMetrics creates the metrics for MinIO stores. Pass them to the stores
+// with DataStoreOptionWithMetrics and register them with a registry.
+func NewMetrics() *Metrics {
+	endpoint := []string{"endpoint"}
+	gauge := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, endpoint, nil)
+	}
+	return &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Store operations by operation and result.",
+		}, []string{"endpoint", "operation", "result"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of store operations by operation and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint", "operation", "result"}),
+		bytesRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "read_bytes_total",
+			Help:      "Bytes of object data returned by Get.",
+		}, endpoint),
+		bytesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "written_bytes_total",
+			Help:      "Bytes of object data written by Set.",
+		}, endpoint),
+		crypto: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "crypto_duration_seconds",
+			Help:      "Duration of encrypting and decrypting object data.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"endpoint", "operation"}),
+		bucketCreations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bucket_creations_total",
+			Help:      "Buckets created automatically by the store.",
+		}, endpoint),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_failures_total",
+			Help:      "S3 requests failed with a transport error or a status the client may retry, including the last attempt.",
+		}, endpoint),
+		connectionsOpen:    gauge("connections_open", "Open connections of the HTTP transport."),
+		connectionsMaxIdle: gauge("connections_max_idle", "Maximum of idle connections kept by the HTTP transport."),
+		requestsInFlight:   gauge("requests_in_flight", "S3 requests sent whose response has not been read completely."),
+		infoBuckets:        gauge("info_buckets", "Buckets counted by the last Info."),
+		infoObjects:        gauge("info_objects", "Objects counted by the last Info."),
+		infoBytes:          gauge("info_size_bytes", "Total size of the objects counted by the last Info."),
+		stores:             make(map[*dataStoreMinio]struct{}),
+	}
+}
+
+// DataStoreOptionWithMetrics lets the store record its metrics in m.
+func DataStoreOptionWithMetrics(m *Metrics) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_METRICS, m)
+}
+
+// metrics returns the configured metrics, nil if none are configured.
+func (dsm *dataStoreMinio) metrics() *Metrics {
+	m, _ := attributeValue[*Metrics](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_METRICS)
+	return m
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.operations.Describe(ch)
+	m.durations.Describe(ch)
+	m.bytesRead.Describe(ch)
+	m.bytesWritten.Describe(ch)
+	m.crypto.Describe(ch)
+	m.bucketCreations.Describe(ch)
+	m.failures.Describe(ch)
+	ch <- m.connectionsOpen
+	ch <- m.connectionsMaxIdle
+	ch <- m.requestsInFlight
+	ch <- m.infoBuckets
+	ch <- m.infoObjects
+	ch <- m.infoBytes
+}
+
+// Collect implements prometheus.Collector. Gauges of stores sharing an
+// endpoint are summed up.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.operations.Collect(ch)
+	m.durations.Collect(ch)
+	m.bytesRead.Collect(ch)
+	m.bytesWritten.Collect(ch)
+	m.crypto.Collect(ch)
+	m.bucketCreations.Collect(ch)
+	m.failures.Collect(ch)
+
+	type gauges struct {
+		open, maxIdle, inFlight float64
+		hasInfo                 bool
+		buckets, objects, size  float64
+	}
+	byEndpoint := make(map[string]*gauges)
+	m.mu.Lock()
+	for dsm := range m.stores {
+		g, ok := byEndpoint[dsm.Endpoint]
+		if !ok {
+			g = &gauges{}
+			byEndpoint[dsm.Endpoint] = g
+		}
+		g.open += float64(dsm.connections.open.Load())
+		g.maxIdle += float64(dsm.connections.maxIdle)
+		g.inFlight += float64(dsm.connections.inFlight.Load())
+		if info := dsm.lastInfo.Load(); info != nil {
+			g.hasInfo = true
+			g.buckets += float64(info.NumBuckets)
+			g.objects += float64(info.NumObjects)
+			g.size += float64(info.TotalSizeInBytes)
+		}
+	}
+	m.mu.Unlock()
+	for endpoint, g := range byEndpoint {
+		ch <- prometheus.MustNewConstMetric(m.connectionsOpen, prometheus.GaugeValue, g.open, endpoint)
+		ch <- prometheus.MustNewConstMetric(m.connectionsMaxIdle, prometheus.GaugeValue, g.maxIdle, endpoint)
+		ch <- prometheus.MustNewConstMetric(m.requestsInFlight, prometheus.GaugeValue, g.inFlight, endpoint)
+		if g.hasInfo {
+			ch <- prometheus.MustNewConstMetric(m.infoBuckets, prometheus.GaugeValue, g.buckets, endpoint)
+			ch <- prometheus.MustNewConstMetric(m.infoObjects, prometheus.GaugeValue, g.objects, endpoint)
+			ch <- prometheus.MustNewConstMetric(m.infoBytes, prometheus.GaugeValue, g.size, endpoint)
+		}
+	}
+}
+
+// register adds a store whose gauges are collected.
+func (m *Metrics) register(dsm *dataStoreMinio) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stores[dsm] = struct{}{}
+}
+
+// unregister removes a closed store.
+func (m *Metrics) unregister(dsm *dataStoreMinio) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.stores, dsm)
+}
+
+// observeOperation records a finished store operation.
+func (m *Metrics) observeOperation(op *operation, err error) {
+	if m == nil {
+		return
+	}
+	result := operationResult(err)
+	m.operations.WithLabelValues(op.dsm.Endpoint, op.name, result).Inc()
+	m.durations.WithLabelValues(op.dsm.Endpoint, op.name, result).Observe(time.Since(op.start).Seconds())
+	if err == nil && op.bytesRead > 0 {
+		m.bytesRead.WithLabelValues(op.dsm.Endpoint).Add(float64(op.bytesRead))
+	}
+	if err == nil && op.bytesWritten > 0 {
+		m.bytesWritten.WithLabelValues(op.dsm.Endpoint).Add(float64(op.bytesWritten))
+	}
+}
+
+// observeCrypto records the duration of encrypting or decrypting data.
+func (m *Metrics) observeCrypto(dsm *dataStoreMinio, operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.crypto.WithLabelValues(dsm.Endpoint, operation).Observe(time.Since(start).Seconds())
+}
+
+// bucketCreated counts a bucket created by the store.
+func (m *Metrics) bucketCreated(dsm *dataStoreMinio) {
+	if m == nil {
+		return
+	}
+	m.bucketCreations.WithLabelValues(dsm.Endpoint).Inc()
+}
+
+// operationResult classifies the error of an operation.
+func operationResult(err error) string {
+	switch {
+	case err == nil:
+		return METRICS_RESULT_SUCCESS
+	case errors.Is(err, ErrPreconditionFailed):
+		return METRICS_RESULT_PRECONDITION_FAILED
+	case isNotFound(err):
+		return METRICS_RESULT_NOT_FOUND
+	default:
+		return METRICS_RESULT_ERROR
+	}
+}
+
+// connectionStats counts the connections and the requests in flight of the
+// HTTP transport of a store.
+type connectionStats struct {
+	open     atomic.Int64
+	maxIdle  int
+	inFlight atomic.Int64
+}
+
+// instrumentTransport counts the connections of the transport and wraps it
+// to count requests in flight and failed requests, if metrics are
+// configured.
+func (dsm *dataStoreMinio) instrumentTransport(transport *http.Transport) http.RoundTripper {
+	m := dsm.metrics()
+	if m == nil {
+		return transport
+	}
+	dsm.connections.maxIdle = transport.MaxIdleConns
+	dialer := &net.Dialer{}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		dsm.connections.open.Add(1)
+		return &countedConn{Conn: conn, closed: sync.OnceFunc(func() { dsm.connections.open.Add(-1) })}, nil
+	}
+	return &metricsTransport{base: transport, dsm: dsm, metrics: m}
+}
+
+// countedConn is a connection counted as open until it is closed.
+type countedConn struct {
+	net.Conn
+	closed func()
+}
+
+func (c *countedConn) Close() error {
+	c.closed()
+	return c.Conn.Close()
+}
+
+// metricsTransport counts the requests in flight and the requests failed
+// with a transport error or a retryable status. Every attempt is counted,
+// the client does not tell whether it retries a failed one.
+type metricsTransport struct {
+	base    http.RoundTripper
+	dsm     *dataStoreMinio
+	metrics *Metrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.dsm.connections.inFlight.Add(1)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.dsm.connections.inFlight.Add(-1)
+		if req.Context().Err() == nil {
+			t.metrics.failures.WithLabelValues(t.dsm.Endpoint).Inc()
+		}
+		return nil, err
+	}
+	if isRetryableStatus(resp.StatusCode) {
+		t.metrics.failures.WithLabelValues(t.dsm.Endpoint).Inc()
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: sync.OnceFunc(func() { t.dsm.connections.inFlight.Add(-1) })}
+	return resp, nil
+}
+
+// isRetryableStatus reports whether the client retries a request answered
+// with this status.
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, 499, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
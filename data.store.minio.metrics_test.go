@@ -0,0 +1,129 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestDataStoreMetrics(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+	metrics := store.NewMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metrics)
+
+	// setup and init store
+	cryptoService, _ := comby.NewCryptoService([]byte("12345678901234567890123456789012"))
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		comby.DataStoreOptionWithCryptoService(cryptoService),
+		store.DataStoreOptionWithMetrics(metrics),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close(ctx)
+
+	// one bucket is created, one request is retried
+	fake.unavailable.Store(1)
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("metrics-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("metrics-bucket"),
+		comby.DataStoreGetOptionWithObjectName("a.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("metrics-bucket"),
+		comby.DataStoreGetOptionWithObjectName("missing.txt"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = dataStore.Info(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := func(name string, labels map[string]string) float64 {
+		for _, family := range families {
+			if family.GetName() != name {
+				continue
+			}
+		metrics:
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if want, ok := labels[label.GetName()]; ok && want != label.GetValue() {
+						continue metrics
+					}
+				}
+				return sampleValue(metric)
+			}
+		}
+		t.Fatalf("missing metric %s %v", name, labels)
+		return 0
+	}
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"comby_minio_operations_total", map[string]string{"operation": "Set", "result": "success"}, 1},
+		{"comby_minio_operations_total", map[string]string{"operation": "Get", "result": "success"}, 1},
+		{"comby_minio_operations_total", map[string]string{"operation": "Get", "result": "not_found"}, 1},
+		{"comby_minio_operation_duration_seconds", map[string]string{"operation": "Get", "result": "success"}, 1},
+		{"comby_minio_read_bytes_total", nil, 5},
+		{"comby_minio_written_bytes_total", nil, 5},
+		{"comby_minio_crypto_duration_seconds", map[string]string{"operation": "encrypt"}, 1},
+		{"comby_minio_crypto_duration_seconds", map[string]string{"operation": "decrypt"}, 1},
+		{"comby_minio_bucket_creations_total", nil, 1},
+		{"comby_minio_request_failures_total", nil, 1},
+		{"comby_minio_connections_max_idle", nil, 20},
+		{"comby_minio_info_buckets", nil, 1},
+		{"comby_minio_info_objects", nil, 1},
+	} {
+		if got := value(tc.name, tc.labels); got != tc.want {
+			t.Errorf("%s %v: got %v, want %v", tc.name, tc.labels, got, tc.want)
+		}
+	}
+	if open := value("comby_minio_connections_open", nil); open < 1 {
+		t.Fatalf("expected open connections, got %v", open)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "endpoint" && label.GetValue() != endpoint {
+					t.Fatalf("wrong endpoint label %s", label.GetValue())
+				}
+			}
+		}
+	}
+}
+
+// sampleValue returns the value of a counter or gauge, or the sample count
+// of a histogram.
+func sampleValue(metric *dto.Metric) float64 {
+	switch {
+	case metric.Counter != nil:
+		return metric.Counter.GetValue()
+	case metric.Gauge != nil:
+		return metric.Gauge.GetValue()
+	case metric.Histogram != nil:
+		return float64(metric.Histogram.GetSampleCount())
+	}
+	return 0
+}
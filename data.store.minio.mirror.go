@@ -0,0 +1,495 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// Values for MirrorOptions.Mode.
+const (
+	// MIRROR_MODE_SYNC replicates before a write returns. Failed
+	// replications are returned and queued for retry.
+	MIRROR_MODE_SYNC = "sync"
+	// MIRROR_MODE_ASYNC queues replications and returns once the local
+	// write succeeded.
+	MIRROR_MODE_ASYNC = "async"
+)
+
+// mirrorSourceETag is the user metadata holding the ETag of the local
+// object a replica was made from. Replicas are re-encrypted, so their own
+// ETags differ from the local ones.
+const mirrorSourceETag = "Mirror-Source-Etag"
+
+// MirrorOptions configure a mirror store.
+type MirrorOptions struct {
+	Mode string
+	// QueueFile persists pending replications, they are kept in memory only
+	// if empty.
+	QueueFile string
+	// RetryInterval is the delay before the first retry of a failed
+	// replication, it doubles with every further attempt.
+	RetryInterval time.Duration
+	// ReconcileInterval runs Reconcile periodically if set.
+	ReconcileInterval time.Duration
+}
+
+type MirrorOption func(opt *MirrorOptions) (*MirrorOptions, error)
+
+// MirrorOptionWithMode sets MIRROR_MODE_SYNC (default) or MIRROR_MODE_ASYNC.
+func MirrorOptionWithMode(mode string) MirrorOption {
+	return func(opt *MirrorOptions) (*MirrorOptions, error) {
+		if mode != MIRROR_MODE_SYNC && mode != MIRROR_MODE_ASYNC {
+			return nil, fmt.Errorf("invalid mirror mode %q", mode)
+		}
+		opt.Mode = mode
+		return opt, nil
+	}
+}
+
+// MirrorOptionWithQueueFile persists pending replications in the file.
+func MirrorOptionWithQueueFile(path string) MirrorOption {
+	return func(opt *MirrorOptions) (*MirrorOptions, error) {
+		opt.QueueFile = path
+		return opt, nil
+	}
+}
+
+// MirrorOptionWithRetryInterval sets the delay before retrying a failed
+// replication.
+func MirrorOptionWithRetryInterval(interval time.Duration) MirrorOption {
+	return func(opt *MirrorOptions) (*MirrorOptions, error) {
+		opt.RetryInterval = interval
+		return opt, nil
+	}
+}
+
+// MirrorOptionWithReconcileInterval runs Reconcile periodically.
+func MirrorOptionWithReconcileInterval(interval time.Duration) MirrorOption {
+	return func(opt *MirrorOptions) (*MirrorOptions, error) {
+		opt.ReconcileInterval = interval
+		return opt, nil
+	}
+}
+
+// ReconcileResult reports the differences found by Reconcile. Each of them
+// was queued for replication.
+type ReconcileResult struct {
+	// Checked is the number of local objects compared.
+	Checked int
+	// Missing objects exist locally but not remotely.
+	Missing int
+	// Outdated objects differ between both sides.
+	Outdated int
+	// Extra objects exist remotely but not locally.
+	Extra int
+}
+
+// DataStoreMirror is a comby.DataStore writing to a local and a remote
+// MinIO store. Reads prefer the local store and fall back to the remote one.
+type DataStoreMirror interface {
+	comby.DataStore
+
+	// Reconcile compares both sides by ETag and queues replications for all
+	// differences. The local side wins.
+	Reconcile(ctx context.Context) (*ReconcileResult, error)
+	// PendingReplications returns the number of queued replications.
+	PendingReplications() int
+}
+
+type dataStoreMirror struct {
+	local   *dataStoreMinio
+	remote  *dataStoreMinio
+	options MirrorOptions
+	queue   *replicationQueue
+
+	// background worker
+	wakeCh chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Make sure it implements interfaces
+var _ comby.DataStore = (*dataStoreMirror)(nil)
+var _ DataStoreMirror = (*dataStoreMirror)(nil)
+
+// NewDataStoreMirror returns a store replicating all writes of the local
+// store to the remote store. Both must have been created with
+// NewDataStoreMinio and are initialized by Init of the mirror.
+func NewDataStoreMirror(local, remote comby.DataStore, opts ...MirrorOption) (DataStoreMirror, error) {
+	localStore, ok := local.(*dataStoreMinio)
+	if !ok {
+		return nil, fmt.Errorf("local store '%s' is not a minio data store", local.String())
+	}
+	remoteStore, ok := remote.(*dataStoreMinio)
+	if !ok {
+		return nil, fmt.Errorf("remote store '%s' is not a minio data store", remote.String())
+	}
+	dsm := &dataStoreMirror{
+		local:  localStore,
+		remote: remoteStore,
+		options: MirrorOptions{
+			Mode:          MIRROR_MODE_SYNC,
+			RetryInterval: 5 * time.Second,
+		},
+		wakeCh: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&dsm.options); err != nil {
+			return nil, err
+		}
+	}
+	return dsm, nil
+}
+
+func (dsm *dataStoreMirror) Init(ctx context.Context, opts ...comby.DataStoreOption) error {
+	if err := dsm.local.Init(ctx, opts...); err != nil {
+		return err
+	}
+	if err := dsm.remote.Init(ctx, opts...); err != nil {
+		return err
+	}
+	queue, err := newReplicationQueue(dsm.options.QueueFile)
+	if err != nil {
+		return fmt.Errorf("failed to load replication queue: %w", err)
+	}
+	dsm.queue = queue
+
+	// stop a worker of a previous Init
+	dsm.stopWorker()
+	workerCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	dsm.cancel = cancel
+	dsm.wg.Add(1)
+	go dsm.worker(workerCtx)
+	return nil
+}
+
+// Get reads from the local store. Only if it is unreachable or fails with a
+// server error, the object is read from the remote store.
+func (dsm *dataStoreMirror) Get(ctx context.Context, opts ...comby.DataStoreGetOption) (*comby.DataModel, error) {
+	model, err := dsm.local.Get(ctx, opts...)
+	if err == nil || !isUnavailable(err) {
+		return model, err
+	}
+	remoteModel, remoteErr := dsm.remote.Get(ctx, opts...)
+	if remoteErr != nil {
+		return nil, err
+	}
+	dsm.local.logger().Warn("read from remote store", "store", dsm.String(), "error", err)
+	return remoteModel, nil
+}
+
+func (dsm *dataStoreMirror) Set(ctx context.Context, opts ...comby.DataStoreSetOption) error {
+	if err := dsm.initialized(); err != nil {
+		return err
+	}
+	if err := dsm.local.Set(ctx, opts...); err != nil {
+		return err
+	}
+	setOpts := comby.DataStoreSetOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&setOpts); err != nil {
+			return err
+		}
+	}
+	return dsm.replicate(ctx, &replicationOp{Type: replicationPut, BucketName: setOpts.BucketName, ObjectName: setOpts.ObjectName})
+}
+
+func (dsm *dataStoreMirror) Copy(ctx context.Context, opts ...comby.DataStoreCopyOption) error {
+	if err := dsm.initialized(); err != nil {
+		return err
+	}
+	if err := dsm.local.Copy(ctx, opts...); err != nil {
+		return err
+	}
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return err
+		}
+	}
+	return dsm.replicate(ctx, &replicationOp{Type: replicationPut, BucketName: copyOpts.DstBucketName, ObjectName: copyOpts.DstObjectName})
+}
+
+func (dsm *dataStoreMirror) List(ctx context.Context, opts ...comby.DataStoreListOption) ([]*comby.DataModel, int64, error) {
+	return dsm.local.List(ctx, opts...)
+}
+
+func (dsm *dataStoreMirror) Delete(ctx context.Context, opts ...comby.DataStoreDeleteOption) error {
+	if err := dsm.initialized(); err != nil {
+		return err
+	}
+	if err := dsm.local.Delete(ctx, opts...); err != nil {
+		return err
+	}
+	deleteOpts := comby.DataStoreDeleteOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&deleteOpts); err != nil {
+			return err
+		}
+	}
+	return dsm.replicate(ctx, &replicationOp{Type: replicationDelete, BucketName: deleteOpts.BucketName, ObjectName: deleteOpts.ObjectName})
+}
+
+func (dsm *dataStoreMirror) Total(ctx context.Context) int64 {
+	return dsm.local.Total(ctx)
+}
+
+func (dsm *dataStoreMirror) Close(ctx context.Context) error {
+	dsm.stopWorker()
+	return errors.Join(dsm.local.Close(ctx), dsm.remote.Close(ctx))
+}
+
+func (dsm *dataStoreMirror) Options() comby.DataStoreOptions {
+	return dsm.local.Options()
+}
+
+func (dsm *dataStoreMirror) String() string {
+	return "mirror://" + dsm.local.Endpoint + "+" + dsm.remote.Endpoint
+}
+
+func (dsm *dataStoreMirror) Info(ctx context.Context) (*comby.DataStoreInfoModel, error) {
+	return dsm.local.Info(ctx)
+}
+
+// Reset resets both stores and drops pending replications.
+func (dsm *dataStoreMirror) Reset(ctx context.Context) error {
+	err := errors.Join(dsm.local.Reset(ctx), dsm.remote.Reset(ctx))
+	if dsm.queue != nil {
+		err = errors.Join(err, dsm.queue.clear())
+	}
+	return err
+}
+
+func (dsm *dataStoreMirror) PendingReplications() int {
+	if dsm.queue == nil {
+		return 0
+	}
+	return dsm.queue.len()
+}
+
+// initialized returns an error until Init loaded the replication queue.
+func (dsm *dataStoreMirror) initialized() error {
+	if dsm.queue == nil {
+		return fmt.Errorf("'%s' is not initialized", dsm.String())
+	}
+	return nil
+}
+
+// isUnavailable reports whether a store could not be reached or failed with
+// a server error, as opposed to e.g. a missing object.
+func isUnavailable(err error) bool {
+	return isConnectionError(err) || minio.ToErrorResponse(err).StatusCode >= http.StatusInternalServerError
+}
+
+// replicate applies op right away in sync mode, failures are queued for
+// retry. In async mode op is only queued.
+func (dsm *dataStoreMirror) replicate(ctx context.Context, op *replicationOp) error {
+	if dsm.options.Mode == MIRROR_MODE_ASYNC {
+		return dsm.enqueue(op)
+	}
+	err := dsm.apply(ctx, op)
+	if err == nil {
+		return nil
+	}
+	if queueErr := dsm.enqueue(op); queueErr != nil {
+		return errors.Join(err, queueErr)
+	}
+	return fmt.Errorf("replication of %s/%s to '%s' queued for retry: %w", op.BucketName, op.ObjectName, dsm.remote.String(), err)
+}
+
+func (dsm *dataStoreMirror) enqueue(op *replicationOp) error {
+	if err := dsm.queue.add(op); err != nil {
+		return fmt.Errorf("failed to queue replication of %s/%s: %w", op.BucketName, op.ObjectName, err)
+	}
+	select {
+	case dsm.wakeCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// apply replicates the current local state of an object.
+func (dsm *dataStoreMirror) apply(ctx context.Context, op *replicationOp) error {
+	if op.Type == replicationPut {
+		objectInfo, err := dsm.local.minioClient.StatObject(ctx, op.BucketName, op.ObjectName, minio.StatObjectOptions{})
+		switch {
+		case minio.ToErrorResponse(err).Code == "NoSuchKey":
+			// removed locally in the meantime
+		case err != nil:
+			return fmt.Errorf("StatObject(%s/%s): %w", op.BucketName, op.ObjectName, err)
+		default:
+			userMetadata := make(map[string]string, len(objectInfo.UserMetadata)+1)
+			for k, v := range objectInfo.UserMetadata {
+				userMetadata[k] = v
+			}
+			userMetadata[mirrorSourceETag] = objectInfo.ETag
+			return CopyAcross(ctx, dsm.local, dsm.remote,
+				comby.DataStoreCopyOptionWithSrcBucketName(op.BucketName),
+				comby.DataStoreCopyOptionWithSrcObjectName(op.ObjectName),
+				comby.DataStoreCopyOptionWithDstBucketName(op.BucketName),
+				comby.DataStoreCopyOptionWithDstObjectName(op.ObjectName),
+				DataStoreCopyOptionWithMetadataDirective(METADATA_DIRECTIVE_REPLACE),
+				DataStoreCopyOptionWithContentType(objectInfo.ContentType),
+				DataStoreCopyOptionWithUserMetadata(userMetadata),
+				DataStoreCopyOptionWithMatchETag(objectInfo.ETag),
+			)
+		}
+	}
+	return dsm.remote.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName(op.BucketName),
+		comby.DataStoreDeleteOptionWithObjectName(op.ObjectName),
+	)
+}
+
+// worker retries queued replications and runs the periodic reconciliation.
+func (dsm *dataStoreMirror) worker(ctx context.Context) {
+	defer dsm.wg.Done()
+	ticker := time.NewTicker(dsm.options.RetryInterval)
+	defer ticker.Stop()
+	var reconcileCh <-chan time.Time
+	if dsm.options.ReconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(dsm.options.ReconcileInterval)
+		defer reconcileTicker.Stop()
+		reconcileCh = reconcileTicker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reconcileCh:
+			if _, err := dsm.Reconcile(ctx); err != nil {
+				dsm.local.logger().Warn("mirror reconciliation failed", "store", dsm.String(), "error", err)
+			}
+		case <-ticker.C:
+		case <-dsm.wakeCh:
+		}
+		for _, op := range dsm.queue.due(time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			var err error
+			if applyErr := dsm.apply(ctx, op); applyErr != nil {
+				dsm.local.logger().Warn("replication failed", "store", dsm.String(), "bucket", op.BucketName,
+					"object", op.ObjectName, "attempts", op.Attempts+1, "error", applyErr)
+				err = dsm.queue.failed(op, applyErr, dsm.options.RetryInterval)
+			} else {
+				err = dsm.queue.done(op)
+			}
+			if err != nil {
+				dsm.local.logger().Warn("failed to persist replication queue", "store", dsm.String(), "error", err)
+			}
+		}
+	}
+}
+
+func (dsm *dataStoreMirror) stopWorker() {
+	if dsm.cancel != nil {
+		dsm.cancel()
+		dsm.wg.Wait()
+		dsm.cancel = nil
+	}
+}
+
+// Reconcile compares the objects of all buckets of both sides. Remote
+// objects are compared by the ETag of the local object they were replicated
+// from.
+func (dsm *dataStoreMirror) Reconcile(ctx context.Context) (*ReconcileResult, error) {
+	result := &ReconcileResult{}
+	if err := dsm.initialized(); err != nil {
+		return result, err
+	}
+	localBuckets, err := dsm.local.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return result, err
+	}
+	remoteBuckets, err := dsm.remote.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return result, err
+	}
+	bucketNames := make(map[string]bool)
+	for _, bucket := range localBuckets {
+		bucketNames[bucket.Name] = true
+	}
+	for _, bucket := range remoteBuckets {
+		bucketNames[bucket.Name] = true
+	}
+
+	for bucketName := range bucketNames {
+		localETags, err := dsm.listETags(ctx, dsm.local, bucketName, false)
+		if err != nil {
+			return result, err
+		}
+		remoteETags, err := dsm.listETags(ctx, dsm.remote, bucketName, true)
+		if err != nil {
+			return result, err
+		}
+		for objectName, etag := range localETags {
+			result.Checked++
+			remoteETag, ok := remoteETags[objectName]
+			switch {
+			case !ok:
+				result.Missing++
+			case remoteETag != etag:
+				result.Outdated++
+			default:
+				continue
+			}
+			if err := dsm.enqueue(&replicationOp{Type: replicationPut, BucketName: bucketName, ObjectName: objectName}); err != nil {
+				return result, err
+			}
+		}
+		for objectName := range remoteETags {
+			if _, ok := localETags[objectName]; ok {
+				continue
+			}
+			result.Extra++
+			if err := dsm.enqueue(&replicationOp{Type: replicationDelete, BucketName: bucketName, ObjectName: objectName}); err != nil {
+				return result, err
+			}
+		}
+	}
+	dsm.local.logger().Info("mirror reconciled", "store", dsm.String(), "checked", result.Checked,
+		"missing", result.Missing, "outdated", result.Outdated, "extra", result.Extra)
+	return result, nil
+}
+
+// listETags returns the ETags of all objects of a bucket, for replicas the
+// ETag of their source. A missing bucket has no objects.
+func (dsm *dataStoreMirror) listETags(ctx context.Context, store *dataStoreMinio, bucketName string, replica bool) (map[string]string, error) {
+	etags := make(map[string]string)
+	objectCh := store.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithMetadata: replica,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			if minio.ToErrorResponse(object.Err).Code == "NoSuchBucket" {
+				return etags, nil
+			}
+			return nil, fmt.Errorf("failed to list %s of '%s': %w", bucketName, store.String(), object.Err)
+		}
+		etag := strings.Trim(object.ETag, `"`)
+		if replica {
+			etag = ""
+			for key, value := range object.UserMetadata {
+				if strings.EqualFold(strings.TrimPrefix(strings.ToLower(key), "x-amz-meta-"), mirrorSourceETag) {
+					etag = strings.Trim(value, `"`)
+				}
+			}
+		}
+		etags[object.Key] = etag
+	}
+	return etags, nil
+}
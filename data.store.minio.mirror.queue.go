@@ -0,0 +1,158 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Values for replicationOp.Type.
+const (
+	replicationPut    = "put"
+	replicationDelete = "delete"
+)
+
+// replicationOp is a pending replication of an object to the remote store.
+type replicationOp struct {
+	Type        string    `json:"type"`
+	BucketName  string    `json:"bucket"`
+	ObjectName  string    `json:"object"`
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+func (op *replicationOp) key() string {
+	return cacheKey(op.BucketName, op.ObjectName)
+}
+
+// replicationQueue holds the latest pending replication per object. It is
+// written to a file on every change if a path is given, so pending
+// replications survive restarts.
+type replicationQueue struct {
+	mu   sync.Mutex
+	path string
+	ops  map[string]*replicationOp
+}
+
+// newReplicationQueue returns a queue with the pending replications loaded
+// from path, if any.
+func newReplicationQueue(path string) (*replicationQueue, error) {
+	q := &replicationQueue{
+		path: path,
+		ops:  make(map[string]*replicationOp),
+	}
+	if path == "" {
+		return q, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ops []*replicationOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		q.ops[op.key()] = op
+	}
+	return q, nil
+}
+
+// add queues op, replacing a pending replication of the same object.
+func (q *replicationQueue) add(op *replicationOp) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ops[op.key()] = op
+	return q.persistLocked()
+}
+
+// due returns the replications to attempt now, oldest object names first.
+func (q *replicationQueue) due(now time.Time) []*replicationOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ops []*replicationOp
+	for _, op := range q.ops {
+		if !op.NextAttempt.After(now) {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].key() < ops[j].key() })
+	return ops
+}
+
+// done removes op unless it was replaced in the meantime.
+func (q *replicationQueue) done(op *replicationOp) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ops[op.key()] != op {
+		return nil
+	}
+	delete(q.ops, op.key())
+	return q.persistLocked()
+}
+
+// failed schedules the next attempt of op with exponential backoff.
+func (q *replicationQueue) failed(op *replicationOp, err error, retryInterval time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ops[op.key()] != op {
+		return nil
+	}
+	op.Attempts++
+	op.LastError = err.Error()
+	backoff := retryInterval << min(op.Attempts-1, 10)
+	op.NextAttempt = time.Now().Add(backoff)
+	return q.persistLocked()
+}
+
+func (q *replicationQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.ops)
+}
+
+func (q *replicationQueue) clear() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ops = make(map[string]*replicationOp)
+	return q.persistLocked()
+}
+
+// persistLocked writes the queue atomically. The caller holds q.mu.
+func (q *replicationQueue) persistLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	ops := make([]*replicationOp, 0, len(q.ops))
+	for _, op := range q.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].key() < ops[j].key() })
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), q.path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package store_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+func TestDataStoreMirror(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	localFake, localEndpoint := newFakeS3(t)
+	remoteFake, remoteEndpoint := newFakeS3(t)
+	queueFile := filepath.Join(t.TempDir(), "replication.json")
+
+	// setup and init mirror
+	newMirror := func() store.DataStoreMirror {
+		mirror, err := store.NewDataStoreMirror(
+			store.NewDataStoreMinio(localEndpoint, false, "ROOTNAME", "CHANGEME123"),
+			store.NewDataStoreMinio(remoteEndpoint, false, "ROOTNAME", "CHANGEME123"),
+			store.MirrorOptionWithQueueFile(queueFile),
+			store.MirrorOptionWithRetryInterval(10*time.Millisecond),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mirror.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return mirror
+	}
+	mirror := newMirror()
+	set := func(objectName, data string) error {
+		return mirror.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("mirror-bucket"),
+			comby.DataStoreSetOptionWithObjectName(objectName),
+			comby.DataStoreSetOptionWithContentType("text/plain"),
+			comby.DataStoreSetOptionWithData([]byte(data)),
+		)
+	}
+	waitReplicated := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for mirror.PendingReplications() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d replications pending", mirror.PendingReplications())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// sync writes reach both sides
+	if err = set("a.txt", "alpha"); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(remoteFake.object("mirror-bucket", "a.txt")); got != "alpha" {
+		t.Fatalf("wrong remote data: %q", got)
+	}
+
+	// a failed replication is returned and retried until the remote is back
+	remoteFake.deny.Store(true)
+	if err = set("b.txt", "beta"); err == nil {
+		t.Fatal("expected replication error")
+	}
+	if mirror.PendingReplications() != 1 {
+		t.Fatalf("expected pending replication, got %d", mirror.PendingReplications())
+	}
+
+	// pending replications survive a restart
+	if err = mirror.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	remoteFake.deny.Store(false)
+	mirror = newMirror()
+	waitReplicated()
+	if got := string(remoteFake.object("mirror-bucket", "b.txt")); got != "beta" {
+		t.Fatalf("wrong remote data: %q", got)
+	}
+
+	// deletes are replicated
+	if err = mirror.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("a.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if remoteFake.object("mirror-bucket", "a.txt") != nil {
+		t.Fatal("expected remote object to be deleted")
+	}
+
+	// reads fall back to the remote side if the local one fails, the status
+	// is not retried by the client
+	localFake.failStatus.Store(http.StatusNotImplemented)
+	model, err := mirror.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreGetOptionWithObjectName("b.txt"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != "beta" {
+		t.Fatalf("wrong data: %s", model.Data)
+	}
+	localFake.failStatus.Store(0)
+
+	// but not if the local side answers, e.g. that the object is missing
+	// or access is denied
+	remoteDataStore := store.NewDataStoreMinio(remoteEndpoint, false, "ROOTNAME", "CHANGEME123")
+	if err = remoteDataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = remoteDataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreSetOptionWithObjectName("c.txt"),
+		comby.DataStoreSetOptionWithData([]byte("extra")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mirror.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreGetOptionWithObjectName("c.txt"),
+	); minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		t.Fatalf("expected local not found, got %v", err)
+	}
+	localFake.deny.Store(true)
+	if _, err = mirror.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreGetOptionWithObjectName("b.txt"),
+	); minio.ToErrorResponse(err).Code != "AccessDenied" {
+		t.Fatalf("expected local access denied, got %v", err)
+	}
+	localFake.deny.Store(false)
+
+	// reconciliation repairs drift on the remote side
+	if err = remoteDataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreSetOptionWithObjectName("b.txt"),
+		comby.DataStoreSetOptionWithData([]byte("tampered")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	result, err := mirror.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 1 || result.Outdated != 1 || result.Extra != 1 || result.Missing != 0 {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	waitReplicated()
+	if got := string(remoteFake.object("mirror-bucket", "b.txt")); got != "beta" {
+		t.Fatalf("wrong remote data: %q", got)
+	}
+	if remoteFake.object("mirror-bucket", "c.txt") != nil {
+		t.Fatal("expected extra remote object to be deleted")
+	}
+
+	// both sides are in sync now
+	if result, err = mirror.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if result.Outdated != 0 || result.Extra != 0 || result.Missing != 0 {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	if err = mirror.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataStoreMirrorAsync(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	_, localEndpoint := newFakeS3(t)
+	remoteFake, remoteEndpoint := newFakeS3(t)
+
+	// setup and init mirror
+	mirror, err := store.NewDataStoreMirror(
+		store.NewDataStoreMinio(localEndpoint, false, "ROOTNAME", "CHANGEME123"),
+		store.NewDataStoreMinio(remoteEndpoint, false, "ROOTNAME", "CHANGEME123"),
+		store.MirrorOptionWithMode(store.MIRROR_MODE_ASYNC),
+		store.MirrorOptionWithRetryInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writes are refused until the replication queue is loaded
+	if err = mirror.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err == nil {
+		t.Fatal("expected error before Init")
+	}
+	if err = mirror.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close(ctx)
+
+	// async writes succeed while the remote is down
+	remoteFake.deny.Store(true)
+	if err = mirror.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("mirror-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if mirror.PendingReplications() != 1 {
+		t.Fatalf("expected pending replication, got %d", mirror.PendingReplications())
+	}
+
+	// and are replicated once it is back
+	remoteFake.deny.Store(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for remoteFake.object("mirror-bucket", "a.txt") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("object not replicated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
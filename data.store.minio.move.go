@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// MoveError is returned by Move when an object could not be moved completely.
+type MoveError struct {
+	SrcBucketName string
+	SrcObjectName string
+	DstBucketName string
+	DstObjectName string
+	// Copied is true if the destination was written, otherwise the copy
+	// failed and nothing was changed.
+	Copied bool
+	// Partial is true if the move stopped halfway. The destination exists
+	// afterwards and the source may still exist as well.
+	Partial bool
+	Err     error
+}
+
+func (e *MoveError) Error() string {
+	state := "rolled back"
+	switch {
+	case !e.Copied:
+		state = "copy failed, source unchanged"
+	case e.Partial:
+		state = "partial, destination kept"
+	}
+	return fmt.Sprintf("move %s/%s to %s/%s failed (%s): %v",
+		e.SrcBucketName, e.SrcObjectName, e.DstBucketName, e.DstObjectName, state, e.Err)
+}
+
+func (e *MoveError) Unwrap() error {
+	return e.Err
+}
+
+// Move copies the source object server-side, verifies that the destination
+// has the same size and ETag, and removes the source. If verification fails,
+// the destination is removed again so that only the source remains. Once the
+// copy is verified the destination is never removed: if the removal of the
+// source fails, or the rollback does, a MoveError with Partial set is
+// returned.
+func (dsm *dataStoreMinio) Move(ctx context.Context, opts ...comby.DataStoreCopyOption) (err error) {
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return err
+		}
+	}
+	if copyOpts.SrcBucketName == copyOpts.DstBucketName && copyOpts.SrcObjectName == copyOpts.DstObjectName {
+		return fmt.Errorf("move %s/%s: source and destination are identical", copyOpts.SrcBucketName, copyOpts.SrcObjectName)
+	}
+	moveErr := &MoveError{
+		SrcBucketName: copyOpts.SrcBucketName,
+		SrcObjectName: copyOpts.SrcObjectName,
+		DstBucketName: copyOpts.DstBucketName,
+		DstObjectName: copyOpts.DstObjectName,
+	}
+	record := dsm.newAuditRecord(ctx, "Move", copyOpts.DstBucketName, copyOpts.DstObjectName)
+	record.SourceBucket, record.SourceKey = copyOpts.SrcBucketName, copyOpts.SrcObjectName
+	defer func() { dsm.audit(ctx, record, err) }()
+
+	if err := dsm.Copy(ctx, opts...); err != nil {
+		moveErr.Err = err
+		return moveErr
+	}
+	moveErr.Copied = true
+
+	// verify destination
+	if err := dsm.verifyCopy(ctx, copyOpts); err != nil {
+		moveErr.Err = err
+		return dsm.rollbackMove(ctx, moveErr)
+	}
+
+	// remove source
+	removeOpts := minio.RemoveObjectOptions{}
+	dsm.invalidateCache(copyOpts.SrcBucketName, copyOpts.SrcObjectName)
+	if err := dsm.minioClient.RemoveObject(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName, removeOpts); err != nil {
+		// the source may be gone despite the error, keep the verified copy
+		moveErr.Partial = true
+		moveErr.Err = fmt.Errorf("RemoveObject(%s/%s): %w", copyOpts.SrcBucketName, copyOpts.SrcObjectName, err)
+		return moveErr
+	}
+	return nil
+}
+
+// MovePrefix moves every object whose name starts with SrcObjectName in
+// SrcBucketName to DstBucketName, replacing the SrcObjectName prefix with
+// DstObjectName. It is meant to rename "folders". All objects are attempted,
+// failed moves are returned joined together as MoveErrors.
+func (dsm *dataStoreMinio) MovePrefix(ctx context.Context, opts ...comby.DataStoreCopyOption) (int, error) {
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return 0, err
+		}
+	}
+	srcPrefix, dstPrefix := copyOpts.SrcObjectName, copyOpts.DstObjectName
+
+	// collect keys first, listing while moving would pick up moved objects
+	var keys []string
+	objectCh := dsm.minioClient.ListObjects(ctx, copyOpts.SrcBucketName, minio.ListObjectsOptions{
+		Prefix:    srcPrefix,
+		Recursive: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return 0, fmt.Errorf("failed to list objects in bucket %s: %w", copyOpts.SrcBucketName, object.Err)
+		}
+		keys = append(keys, object.Key)
+	}
+
+	moved := 0
+	var errs []error
+	for _, key := range keys {
+		dstKey := dstPrefix + strings.TrimPrefix(key, srcPrefix)
+		objectOpts := append(opts[:len(opts):len(opts)],
+			comby.DataStoreCopyOptionWithSrcObjectName(key),
+			comby.DataStoreCopyOptionWithDstObjectName(dstKey),
+		)
+		if err := dsm.Move(ctx, objectOpts...); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		moved++
+	}
+	return moved, errors.Join(errs...)
+}
+
+// verifyCopy compares size and ETag of source and destination. ETags of
+// multipart copies are computed from the parts and are only compared by size.
+func (dsm *dataStoreMinio) verifyCopy(ctx context.Context, copyOpts comby.DataStoreCopyOptions) error {
+	srcInfo, err := dsm.minioClient.StatObject(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("StatObject(%s/%s): %w", copyOpts.SrcBucketName, copyOpts.SrcObjectName, err)
+	}
+	dstInfo, err := dsm.minioClient.StatObject(ctx, copyOpts.DstBucketName, copyOpts.DstObjectName, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("StatObject(%s/%s): %w", copyOpts.DstBucketName, copyOpts.DstObjectName, err)
+	}
+	if srcInfo.Size != dstInfo.Size {
+		return fmt.Errorf("size mismatch after copy: source %d, destination %d", srcInfo.Size, dstInfo.Size)
+	}
+	isMultipart := strings.Contains(srcInfo.ETag, "-") || strings.Contains(dstInfo.ETag, "-")
+	if !isMultipart && srcInfo.ETag != dstInfo.ETag {
+		return fmt.Errorf("etag mismatch after copy: source %q, destination %q", srcInfo.ETag, dstInfo.ETag)
+	}
+	return nil
+}
+
+// rollbackMove removes the destination of a failed move. The returned
+// MoveError is marked partial if the destination could not be removed.
+func (dsm *dataStoreMinio) rollbackMove(ctx context.Context, moveErr *MoveError) error {
+	removeOpts := minio.RemoveObjectOptions{}
+	if err := dsm.minioClient.RemoveObject(ctx, moveErr.DstBucketName, moveErr.DstObjectName, removeOpts); err != nil {
+		moveErr.Partial = true
+		moveErr.Err = errors.Join(moveErr.Err, fmt.Errorf("rollback RemoveObject(%s/%s): %w", moveErr.DstBucketName, moveErr.DstObjectName, err))
+	}
+	return moveErr
+}
@@ -0,0 +1,173 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreMove(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set values below a "folder"
+	for _, objectName := range []string{"folder/a", "folder/b", "folder/sub/c"} {
+		if err := dataStore.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("move-bucket"),
+			comby.DataStoreSetOptionWithObjectName(objectName),
+			comby.DataStoreSetOptionWithContentType("text/plain"),
+			comby.DataStoreSetOptionWithData([]byte(objectName)),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Move a single object
+	if err := dataStore.Move(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("folder/a"),
+		comby.DataStoreCopyOptionWithDstBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("folder/a-renamed"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if dataModel, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("move-bucket"),
+		comby.DataStoreGetOptionWithObjectName("folder/a-renamed"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if string(dataModel.Data) != "folder/a" {
+			t.Fatalf("wrong value after move: %q", dataModel.Data)
+		}
+	}
+
+	// Move the whole folder into another bucket
+	if moved, err := dataStore.MovePrefix(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("folder/"),
+		comby.DataStoreCopyOptionWithDstBucketName("move-target"),
+		comby.DataStoreCopyOptionWithDstObjectName("renamed/"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if moved != 3 {
+			t.Fatalf("wrong number of moved objects: %d", moved)
+		}
+	}
+	if dataModel, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("move-target"),
+		comby.DataStoreGetOptionWithObjectName("renamed/sub/c"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if string(dataModel.Data) != "folder/sub/c" {
+			t.Fatalf("wrong value after prefix move: %q", dataModel.Data)
+		}
+	}
+
+	// check totals, no duplicates left behind
+	if dataStore.Total(ctx) != 3 {
+		t.Fatalf("wrong total after move: %d", dataStore.Total(ctx))
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
+
+func TestDataStoreMoveCopyFailed(t *testing.T) {
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err := dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("move-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fake does not copy server-side, nothing is written or rolled back
+	err := dataStore.Move(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("a.txt"),
+		comby.DataStoreCopyOptionWithDstBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("b.txt"),
+	)
+	var moveErr *store.MoveError
+	if !errors.As(err, &moveErr) {
+		t.Fatalf("expected MoveError, got %v", err)
+	}
+	if moveErr.Copied || moveErr.Partial || !strings.Contains(moveErr.Error(), "copy failed, source unchanged") {
+		t.Fatalf("wrong error: %v", moveErr)
+	}
+	if string(fake.object("move-bucket", "a.txt")) != "alpha" || fake.object("move-bucket", "b.txt") != nil {
+		t.Fatal("expected source only")
+	}
+}
+
+func TestDataStoreMoveRemoveFailed(t *testing.T) {
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+	fake.copies.Store(true)
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err := dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("move-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// the source is removed although the removal fails, the verified copy
+	// must be kept
+	fake.failDeletes.Store(1)
+	err := dataStore.Move(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("a.txt"),
+		comby.DataStoreCopyOptionWithDstBucketName("move-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("b.txt"),
+	)
+	var moveErr *store.MoveError
+	if !errors.As(err, &moveErr) {
+		t.Fatalf("expected MoveError, got %v", err)
+	}
+	if !moveErr.Copied || !moveErr.Partial || !strings.Contains(moveErr.Error(), "partial, destination kept") {
+		t.Fatalf("wrong error: %v", moveErr)
+	}
+	if string(fake.object("move-bucket", "b.txt")) != "alpha" {
+		t.Fatal("expected destination to be kept")
+	}
+}
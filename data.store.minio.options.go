@@ -0,0 +1,237 @@
+package store
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+)
+
+// Attribute keys understood by the MinIO store in addition to the ones
+// defined by comby. They are set through the option helpers below.
+const (
+	// DATA_STORE_ATTRIBUTE_METADATA_DIRECTIVE decides whether Copy keeps the
+	// metadata of the source (METADATA_DIRECTIVE_COPY, default) or replaces it
+	// (METADATA_DIRECTIVE_REPLACE) with the content type and user metadata
+	// given as attributes.
+	DATA_STORE_ATTRIBUTE_METADATA_DIRECTIVE = "minio.metadataDirective"
+	// DATA_STORE_ATTRIBUTE_CONTENT_TYPE holds the content type (string) used
+	// when metadata is replaced.
+	DATA_STORE_ATTRIBUTE_CONTENT_TYPE = "minio.contentType"
+	// DATA_STORE_ATTRIBUTE_USER_METADATA holds user metadata
+	// (map[string]string) used when metadata is replaced.
+	DATA_STORE_ATTRIBUTE_USER_METADATA = "minio.userMetadata"
+	// DATA_STORE_ATTRIBUTE_TAGS holds object tags (map[string]string). If
+	// present the tags of the destination are replaced, otherwise the tags of
+	// the source are copied.
+	DATA_STORE_ATTRIBUTE_TAGS = "minio.tags"
+	// DATA_STORE_ATTRIBUTE_MATCH_ETAG restricts Copy to sources and Set to
+	// existing objects with this ETag (string).
+	DATA_STORE_ATTRIBUTE_MATCH_ETAG = "minio.matchETag"
+	// DATA_STORE_ATTRIBUTE_NONE_MATCH_ETAG restricts Copy to sources with
+	// another ETag than this one (string).
+	DATA_STORE_ATTRIBUTE_NONE_MATCH_ETAG = "minio.noneMatchETag"
+	// DATA_STORE_ATTRIBUTE_MODIFIED_SINCE restricts Copy to sources modified
+	// after this time (time.Time).
+	DATA_STORE_ATTRIBUTE_MODIFIED_SINCE = "minio.modifiedSince"
+	// DATA_STORE_ATTRIBUTE_UNMODIFIED_SINCE restricts Copy to sources not
+	// modified after this time (time.Time).
+	DATA_STORE_ATTRIBUTE_UNMODIFIED_SINCE = "minio.unmodifiedSince"
+	// DATA_STORE_ATTRIBUTE_DELETE_CONCURRENCY limits the number of buckets
+	// processed in parallel by batch deletes and Reset (int).
+	DATA_STORE_ATTRIBUTE_DELETE_CONCURRENCY = "minio.deleteConcurrency"
+	// DATA_STORE_ATTRIBUTE_VERSIONING enables versioning on buckets created by
+	// the store (bool).
+	DATA_STORE_ATTRIBUTE_VERSIONING = "minio.versioning"
+	// DATA_STORE_ATTRIBUTE_RETENTION_MODE sets the retention mode (string) of
+	// an object written by Set.
+	DATA_STORE_ATTRIBUTE_RETENTION_MODE = "minio.retentionMode"
+	// DATA_STORE_ATTRIBUTE_RETAIN_UNTIL sets the end of the retention
+	// (time.Time) of an object written by Set.
+	DATA_STORE_ATTRIBUTE_RETAIN_UNTIL = "minio.retainUntil"
+	// DATA_STORE_ATTRIBUTE_LEGAL_HOLD places a legal hold (bool) on an object
+	// written by Set.
+	DATA_STORE_ATTRIBUTE_LEGAL_HOLD = "minio.legalHold"
+	// DATA_STORE_ATTRIBUTE_RESET_GOVERNANCE_BYPASS lets Reset remove object
+	// versions under governance retention (bool).
+	DATA_STORE_ATTRIBUTE_RESET_GOVERNANCE_BYPASS = "minio.resetGovernanceBypass"
+	// DATA_STORE_ATTRIBUTE_ALLOW_RESET allows Reset on any endpoint (bool).
+	DATA_STORE_ATTRIBUTE_ALLOW_RESET = "minio.allowReset"
+	// DATA_STORE_ATTRIBUTE_RESET_ALLOWLIST holds host patterns ([]string) of
+	// endpoints on which Reset is allowed.
+	DATA_STORE_ATTRIBUTE_RESET_ALLOWLIST = "minio.resetAllowlist"
+	// DATA_STORE_ATTRIBUTE_LOGGER holds the logger (*slog.Logger) of the store.
+	DATA_STORE_ATTRIBUTE_LOGGER = "minio.logger"
+	// DATA_STORE_ATTRIBUTE_LIFECYCLE_RULES holds lifecycle rules
+	// ([]LifecycleRule) attached to buckets created by the store.
+	DATA_STORE_ATTRIBUTE_LIFECYCLE_RULES = "minio.lifecycleRules"
+	// DATA_STORE_ATTRIBUTE_BUCKET_POLICY holds the policy (PolicyTemplate)
+	// of buckets created by the store.
+	DATA_STORE_ATTRIBUTE_BUCKET_POLICY = "minio.bucketPolicy"
+	// DATA_STORE_ATTRIBUTE_PUBLIC_PREFIX holds the prefix (string) below
+	// which all objects are public.
+	DATA_STORE_ATTRIBUTE_PUBLIC_PREFIX = "minio.publicPrefix"
+	// DATA_STORE_ATTRIBUTE_PUBLIC_OBJECT makes the object written by Set
+	// public (bool).
+	DATA_STORE_ATTRIBUTE_PUBLIC_OBJECT = "minio.publicObject"
+	// DATA_STORE_ATTRIBUTE_CACHE_MAX_BYTES limits the size (int64) of the
+	// in-memory object cache.
+	DATA_STORE_ATTRIBUTE_CACHE_MAX_BYTES = "minio.cacheMaxBytes"
+	// DATA_STORE_ATTRIBUTE_CACHE_DIR holds the directory (string) of the
+	// on-disk object cache.
+	DATA_STORE_ATTRIBUTE_CACHE_DIR = "minio.cacheDir"
+	// DATA_STORE_ATTRIBUTE_CACHE_DECRYPTED caches decrypted objects (bool).
+	DATA_STORE_ATTRIBUTE_CACHE_DECRYPTED = "minio.cacheDecrypted"
+	// DATA_STORE_ATTRIBUTE_GET_COALESCING lets concurrent Gets of the same
+	// object share a download (bool).
+	DATA_STORE_ATTRIBUTE_GET_COALESCING = "minio.getCoalescing"
+	// DATA_STORE_ATTRIBUTE_CREATE_ONLY restricts Set and CopyAcross to
+	// objects that do not exist yet (bool).
+	DATA_STORE_ATTRIBUTE_CREATE_ONLY = "minio.createOnly"
+	// DATA_STORE_ATTRIBUTE_ENDPOINTS holds further endpoints ([]string)
+	// serving the same data as the endpoint of the store.
+	DATA_STORE_ATTRIBUTE_ENDPOINTS = "minio.endpoints"
+	// DATA_STORE_ATTRIBUTE_HEALTH_CHECK_INTERVAL sets the interval
+	// (time.Duration) in which endpoints are probed.
+	DATA_STORE_ATTRIBUTE_HEALTH_CHECK_INTERVAL = "minio.healthCheckInterval"
+	// DATA_STORE_ATTRIBUTE_HEDGE_AFTER sets the latency (time.Duration) after
+	// which Get sends a second request.
+	DATA_STORE_ATTRIBUTE_HEDGE_AFTER = "minio.hedgeAfter"
+	// DATA_STORE_ATTRIBUTE_TRACER_PROVIDER holds the tracer provider
+	// (trace.TracerProvider) of the store.
+	DATA_STORE_ATTRIBUTE_TRACER_PROVIDER = "minio.tracerProvider"
+	// DATA_STORE_ATTRIBUTE_METRICS holds the metrics (*Metrics) recorded by
+	// the store.
+	DATA_STORE_ATTRIBUTE_METRICS = "minio.metrics"
+	// DATA_STORE_ATTRIBUTE_AUDIT_SINKS holds the sinks ([]AuditSink) of the
+	// audit records of the store.
+	DATA_STORE_ATTRIBUTE_AUDIT_SINKS = "minio.auditSinks"
+	// DATA_STORE_ATTRIBUTE_AUDIT_IDENTITY holds the function
+	// (AuditIdentityFunc) returning actor and tenant of an operation.
+	DATA_STORE_ATTRIBUTE_AUDIT_IDENTITY = "minio.auditIdentity"
+)
+
+// Values for DATA_STORE_ATTRIBUTE_METADATA_DIRECTIVE.
+const (
+	METADATA_DIRECTIVE_COPY    = "COPY"
+	METADATA_DIRECTIVE_REPLACE = "REPLACE"
+)
+
+// DataStoreOptionWithDeleteConcurrency limits the number of buckets processed
+// in parallel by DeleteBatch, DeletePrefix and Reset.
+func DataStoreOptionWithDeleteConcurrency(concurrency int) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_DELETE_CONCURRENCY, concurrency)
+}
+
+// DataStoreOptionWithVersioning enables versioning on buckets created by the
+// store.
+func DataStoreOptionWithVersioning(enabled bool) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_VERSIONING, enabled)
+}
+
+// DataStoreOptionWithResetGovernanceBypass lets Reset remove object versions
+// under governance retention. The credentials need the permission to bypass
+// governance retention.
+func DataStoreOptionWithResetGovernanceBypass(enabled bool) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_RESET_GOVERNANCE_BYPASS, enabled)
+}
+
+// DataStoreOptionWithAllowReset allows Reset regardless of the endpoint.
+func DataStoreOptionWithAllowReset(allowed bool) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_ALLOW_RESET, allowed)
+}
+
+// DataStoreOptionWithResetAllowlist replaces the host patterns (as understood
+// by path.Match) of endpoints on which Reset is allowed. By default only
+// loopback endpoints are allowed.
+func DataStoreOptionWithResetAllowlist(patterns ...string) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_RESET_ALLOWLIST, patterns)
+}
+
+// DataStoreOptionWithLogger sets the logger of the store. slog.Default() is
+// used if none is given.
+func DataStoreOptionWithLogger(logger *slog.Logger) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_LOGGER, logger)
+}
+
+// DataStoreCopyOptionWithMetadataDirective sets whether Copy keeps or
+// replaces the metadata of the source object.
+func DataStoreCopyOptionWithMetadataDirective(directive string) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_METADATA_DIRECTIVE, directive)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithContentType sets the content type of the destination
+// when metadata is replaced.
+func DataStoreCopyOptionWithContentType(contentType string) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_CONTENT_TYPE, contentType)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithUserMetadata sets the user metadata of the
+// destination when metadata is replaced.
+func DataStoreCopyOptionWithUserMetadata(userMetadata map[string]string) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_USER_METADATA, userMetadata)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithTags replaces the tags of the destination.
+func DataStoreCopyOptionWithTags(tags map[string]string) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_TAGS, tags)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithMatchETag copies only if the source has this ETag.
+func DataStoreCopyOptionWithMatchETag(etag string) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_MATCH_ETAG, etag)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithNoneMatchETag copies only if the source does not
+// have this ETag.
+func DataStoreCopyOptionWithNoneMatchETag(etag string) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_NONE_MATCH_ETAG, etag)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithModifiedSince copies only if the source was modified
+// after the given time.
+func DataStoreCopyOptionWithModifiedSince(since time.Time) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_MODIFIED_SINCE, since)
+		return opt, nil
+	}
+}
+
+// DataStoreCopyOptionWithUnmodifiedSince copies only if the source was not
+// modified after the given time.
+func DataStoreCopyOptionWithUnmodifiedSince(since time.Time) comby.DataStoreCopyOption {
+	return func(opt *comby.DataStoreCopyOptions) (*comby.DataStoreCopyOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_UNMODIFIED_SINCE, since)
+		return opt, nil
+	}
+}
+
+// attributeValue returns the attribute stored under key if it has type T.
+func attributeValue[T any](attributes *comby.Attributes, key string) (T, bool) {
+	var zero T
+	if attributes == nil {
+		return zero, false
+	}
+	if val, ok := attributes.Get(key).(T); ok {
+		return val, true
+	}
+	return zero, false
+}
@@ -0,0 +1,397 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// BucketPolicy is an S3 bucket policy document.
+type BucketPolicy struct {
+	Version   string            `json:"Version"`
+	ID        string            `json:"Id,omitempty"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a single statement of a bucket policy. Elements without
+// a field of their own are kept in Extra, so that a policy read from a bucket
+// is written back unchanged.
+type PolicyStatement struct {
+	Sid          string                              `json:"Sid,omitempty"`
+	Effect       string                              `json:"Effect"`
+	Principal    PolicyPrincipal                     `json:"Principal"`
+	NotPrincipal PolicyPrincipal                     `json:"NotPrincipal"`
+	Action       PolicyStrings                       `json:"Action,omitempty"`
+	NotAction    PolicyStrings                       `json:"NotAction,omitempty"`
+	Resource     PolicyStrings                       `json:"Resource,omitempty"`
+	NotResource  PolicyStrings                       `json:"NotResource,omitempty"`
+	Condition    map[string]map[string]PolicyStrings `json:"Condition,omitempty"`
+	Extra        map[string]json.RawMessage          `json:"-"`
+}
+
+// policyStatement encodes the fields of a PolicyStatement.
+type policyStatement PolicyStatement
+
+func (s *PolicyStatement) UnmarshalJSON(data []byte) error {
+	var statement policyStatement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return err
+	}
+	var elements map[string]json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return err
+	}
+	for name, value := range elements {
+		if !policyStatementElements[name] {
+			if statement.Extra == nil {
+				statement.Extra = make(map[string]json.RawMessage)
+			}
+			statement.Extra[name] = value
+		}
+	}
+	*s = PolicyStatement(statement)
+	return nil
+}
+
+func (s PolicyStatement) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(policyStatement(s))
+	if err != nil {
+		return nil, err
+	}
+	var elements map[string]json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, err
+	}
+	// a statement has either a principal or excludes principals
+	if s.Principal.empty() {
+		delete(elements, "Principal")
+	}
+	if s.NotPrincipal.empty() {
+		delete(elements, "NotPrincipal")
+	}
+	for name, value := range s.Extra {
+		if !policyStatementElements[name] {
+			elements[name] = value
+		}
+	}
+	return json.Marshal(elements)
+}
+
+// policyStatementElements are the elements with a field in PolicyStatement.
+var policyStatementElements = map[string]bool{
+	"Sid": true, "Effect": true, "Principal": true, "NotPrincipal": true, "Action": true,
+	"NotAction": true, "Resource": true, "NotResource": true, "Condition": true,
+}
+
+// PolicyPrincipal names the principals a statement applies to. The
+// principal "*" is read as {"AWS": ["*"]}.
+type PolicyPrincipal struct {
+	AWS           PolicyStrings `json:"AWS,omitempty"`
+	Service       PolicyStrings `json:"Service,omitempty"`
+	Federated     PolicyStrings `json:"Federated,omitempty"`
+	CanonicalUser PolicyStrings `json:"CanonicalUser,omitempty"`
+}
+
+// policyPrincipal encodes the fields of a PolicyPrincipal.
+type policyPrincipal PolicyPrincipal
+
+func (p *PolicyPrincipal) UnmarshalJSON(data []byte) error {
+	var principal string
+	if err := json.Unmarshal(data, &principal); err == nil {
+		*p = PolicyPrincipal{AWS: PolicyStrings{principal}}
+		return nil
+	}
+	return json.Unmarshal(data, (*policyPrincipal)(p))
+}
+
+func (p PolicyPrincipal) empty() bool {
+	return len(p.AWS) == 0 && len(p.Service) == 0 && len(p.Federated) == 0 && len(p.CanonicalUser) == 0
+}
+
+// sorted returns the principal with sorted values.
+func (p PolicyPrincipal) sorted() PolicyPrincipal {
+	return PolicyPrincipal{
+		AWS:           sortedStrings(p.AWS),
+		Service:       sortedStrings(p.Service),
+		Federated:     sortedStrings(p.Federated),
+		CanonicalUser: sortedStrings(p.CanonicalUser),
+	}
+}
+
+// PolicyStrings are the values of a policy element, which may be written as
+// a single string or as an array. They are always written as an array.
+type PolicyStrings []string
+
+func (s *PolicyStrings) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*s = PolicyStrings{value}
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*s = values
+	return nil
+}
+
+// PolicyTemplate returns the policy of a bucket. A nil policy means the
+// bucket is private.
+type PolicyTemplate func(bucketName string) (*BucketPolicy, error)
+
+// policyVersion is the version of the policy language.
+const policyVersion = "2012-10-17"
+
+// PolicyPublicRead allows anonymous reads of the objects below the given
+// prefixes, or of all objects if no prefix is given.
+func PolicyPublicRead(prefixes ...string) PolicyTemplate {
+	return func(bucketName string) (*BucketPolicy, error) {
+		return &BucketPolicy{
+			Version: policyVersion,
+			Statement: []PolicyStatement{
+				publicGetStatement(bucketName, prefixes),
+			},
+		}, nil
+	}
+}
+
+// PolicyPublicReadList allows anonymous reads and listing of the objects
+// below the given prefixes, or of all objects if no prefix is given.
+func PolicyPublicReadList(prefixes ...string) PolicyTemplate {
+	return func(bucketName string) (*BucketPolicy, error) {
+		listStatement := PolicyStatement{
+			Effect:    "Allow",
+			Principal: PolicyPrincipal{AWS: []string{"*"}},
+			Action:    []string{"s3:ListBucket", "s3:GetBucketLocation"},
+			Resource:  []string{bucketARN(bucketName)},
+		}
+		if len(prefixes) > 0 {
+			listStatement.Action = []string{"s3:ListBucket"}
+			listStatement.Condition = map[string]map[string]PolicyStrings{
+				"StringLike": {"s3:prefix": prefixPatterns(prefixes)},
+			}
+		}
+		return &BucketPolicy{
+			Version: policyVersion,
+			Statement: []PolicyStatement{
+				listStatement,
+				publicGetStatement(bucketName, prefixes),
+			},
+		}, nil
+	}
+}
+
+// PolicyPrivate removes any bucket policy.
+func PolicyPrivate() PolicyTemplate {
+	return func(bucketName string) (*BucketPolicy, error) {
+		return nil, nil
+	}
+}
+
+// PolicyFromJSON returns a custom policy template. The placeholder
+// ${bucket} in the document is replaced by the bucket name. A bucket name
+// that makes the document invalid is reported as error by the template.
+func PolicyFromJSON(document string) (PolicyTemplate, error) {
+	// validate once, the bucket name does not change the structure
+	var policy BucketPolicy
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return func(bucketName string) (*BucketPolicy, error) {
+		var policy BucketPolicy
+		expanded := bytes.ReplaceAll([]byte(document), []byte("${bucket}"), []byte(bucketName))
+		if err := json.Unmarshal(expanded, &policy); err != nil {
+			return nil, fmt.Errorf("invalid policy for bucket %s: %w", bucketName, err)
+		}
+		return &policy, nil
+	}, nil
+}
+
+// DataStoreOptionWithBucketPolicy sets the policy of buckets created by the
+// store and the policy enforced by ReconcileBucketPolicies. It takes
+// precedence over comby.DATA_STORE_ATTRIBUTE_IS_PUBLIC.
+func DataStoreOptionWithBucketPolicy(template PolicyTemplate) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_BUCKET_POLICY, template)
+}
+
+// GetBucketPolicy returns the policy of a bucket, nil if the bucket has none.
+func (dsm *dataStoreMinio) GetBucketPolicy(ctx context.Context, bucketName string) (*BucketPolicy, error) {
+	document, err := dsm.minioClient.GetBucketPolicy(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchBucketPolicy" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("GetBucketPolicy(%s): %w", bucketName, err)
+	}
+	if document == "" {
+		return nil, nil
+	}
+	var policy BucketPolicy
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return nil, fmt.Errorf("GetBucketPolicy(%s): %w", bucketName, err)
+	}
+	return &policy, nil
+}
+
+// SetBucketPolicy replaces the policy of a bucket. A nil policy removes it,
+// which makes the bucket private.
+func (dsm *dataStoreMinio) SetBucketPolicy(ctx context.Context, bucketName string, policy *BucketPolicy) error {
+	document := ""
+	if policy != nil {
+		data, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		document = string(data)
+	}
+	if err := dsm.minioClient.SetBucketPolicy(ctx, bucketName, document); err != nil {
+		return fmt.Errorf("SetBucketPolicy(%s): %w", bucketName, err)
+	}
+	// statements for public objects may be gone
+	dsm.publicBuckets.Delete(bucketName)
+	return nil
+}
+
+// ReconcileBucketPolicies applies the policy configured with
+// DataStoreOptionWithBucketPolicy to all existing buckets and returns the
+// number of changed buckets. Without a configured policy nothing is changed.
+// Buckets of leases and audit sinks are left alone, and the statements for
+// public objects (see DataStoreSetOptionWithPublic) are kept.
+func (dsm *dataStoreMinio) ReconcileBucketPolicies(ctx context.Context) (int, error) {
+	template, ok := attributeValue[PolicyTemplate](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_BUCKET_POLICY)
+	if !ok || template == nil {
+		return 0, nil
+	}
+	buckets, err := dsm.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, bucket := range buckets {
+		if _, ok := dsm.leaseBuckets.Load(bucket.Name); ok || dsm.isAuditBucket(bucket.Name) {
+			continue
+		}
+		want, err := template(bucket.Name)
+		if err != nil {
+			return changed, err
+		}
+		ok, err := dsm.reconcileBucketPolicy(ctx, bucket.Name, want)
+		if err != nil {
+			return changed, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// reconcileBucketPolicy sets the policy if it differs from the current one
+// and reports whether it was changed. Statements for public objects of the
+// current policy are added to the wanted one.
+func (dsm *dataStoreMinio) reconcileBucketPolicy(ctx context.Context, bucketName string, want *BucketPolicy) (bool, error) {
+	have, err := dsm.GetBucketPolicy(ctx, bucketName)
+	if err != nil {
+		return false, err
+	}
+	want = withPublicObjectStatements(want, have)
+	if samePolicy(have, want) {
+		return false, nil
+	}
+	return true, dsm.SetBucketPolicy(ctx, bucketName, want)
+}
+
+// bucketPolicy returns the policy template for a new bucket: the configured
+// one, otherwise public read for public buckets.
+func (dsm *dataStoreMinio) bucketPolicy(public bool) PolicyTemplate {
+	if template, ok := attributeValue[PolicyTemplate](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_BUCKET_POLICY); ok && template != nil {
+		return template
+	}
+	if public {
+		return PolicyPublicRead()
+	}
+	return nil
+}
+
+func publicGetStatement(bucketName string, prefixes []string) PolicyStatement {
+	resources := []string{bucketARN(bucketName) + "/*"}
+	if len(prefixes) > 0 {
+		resources = resources[:0]
+		for _, pattern := range prefixPatterns(prefixes) {
+			resources = append(resources, bucketARN(bucketName)+"/"+pattern)
+		}
+	}
+	return PolicyStatement{
+		Effect:    "Allow",
+		Principal: PolicyPrincipal{AWS: []string{"*"}},
+		Action:    []string{"s3:GetObject"},
+		Resource:  resources,
+	}
+}
+
+func prefixPatterns(prefixes []string) []string {
+	patterns := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		patterns = append(patterns, prefix+"*")
+	}
+	return patterns
+}
+
+func bucketARN(bucketName string) string {
+	return "arn:aws:s3:::" + bucketName
+}
+
+// samePolicy compares two policies by their normalized JSON encoding, the
+// order of statements and of values does not matter, as servers may return
+// a policy in another order than it was set.
+func samePolicy(a, b *BucketPolicy) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	dataA, errA := normalizedPolicy(a)
+	dataB, errB := normalizedPolicy(b)
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// normalizedPolicy encodes the policy with sorted values and statements.
+func normalizedPolicy(policy *BucketPolicy) ([]byte, error) {
+	statements := make([]string, 0, len(policy.Statement))
+	for _, statement := range policy.Statement {
+		statement.Principal = statement.Principal.sorted()
+		statement.NotPrincipal = statement.NotPrincipal.sorted()
+		statement.Action = sortedStrings(statement.Action)
+		statement.NotAction = sortedStrings(statement.NotAction)
+		statement.Resource = sortedStrings(statement.Resource)
+		statement.NotResource = sortedStrings(statement.NotResource)
+		conditions := make(map[string]map[string]PolicyStrings, len(statement.Condition))
+		for operator, values := range statement.Condition {
+			conditions[operator] = make(map[string]PolicyStrings, len(values))
+			for key, value := range values {
+				conditions[operator][key] = sortedStrings(value)
+			}
+		}
+		statement.Condition = conditions
+		data, err := json.Marshal(statement)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, string(data))
+	}
+	sort.Strings(statements)
+	return json.Marshal(struct {
+		Version   string
+		Statement []string
+	}{policy.Version, statements})
+}
+
+func sortedStrings(values PolicyStrings) PolicyStrings {
+	sorted := append(PolicyStrings(nil), values...)
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,253 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStorePolicyTemplates(t *testing.T) {
+	policy, err := store.PolicyPublicRead("avatars/", "logos/")("assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 1 {
+		t.Fatalf("wrong statements: %+v", policy.Statement)
+	}
+	resources := policy.Statement[0].Resource
+	if len(resources) != 2 || resources[0] != "arn:aws:s3:::assets/avatars/*" || resources[1] != "arn:aws:s3:::assets/logos/*" {
+		t.Fatalf("wrong resources: %v", resources)
+	}
+
+	if policy, err = store.PolicyPublicReadList("avatars/")("assets"); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 2 || policy.Statement[0].Condition["StringLike"]["s3:prefix"][0] != "avatars/*" {
+		t.Fatalf("wrong statements: %+v", policy.Statement)
+	}
+
+	if policy, err = store.PolicyPrivate()("assets"); err != nil || policy != nil {
+		t.Fatalf("expected no policy, got %+v, %v", policy, err)
+	}
+
+	template, err := store.PolicyFromJSON(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": {"AWS": ["*"]},
+			"Action": ["s3:GetObject"],
+			"Resource": ["arn:aws:s3:::${bucket}/public/*"]
+		}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = template("assets"); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Statement[0].Resource[0] != "arn:aws:s3:::assets/public/*" {
+		t.Fatalf("wrong resource: %v", policy.Statement[0].Resource)
+	}
+	if _, err := template(`a"b`); err == nil {
+		t.Fatal("expected invalid expanded policy")
+	}
+	if _, err := store.PolicyFromJSON(`{"Statement": `); err == nil {
+		t.Fatal("expected invalid policy")
+	}
+
+	// single values may be written without array
+	template, err = store.PolicyFromJSON(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": "s3:GetObject",
+			"Resource": "arn:aws:s3:::${bucket}/*",
+			"Condition": {"StringEquals": {"s3:ExistingObjectTag/public": "true"}}
+		}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = template("assets"); err != nil {
+		t.Fatal(err)
+	}
+	statement := policy.Statement[0]
+	if len(statement.Principal.AWS) != 1 || statement.Principal.AWS[0] != "*" || len(statement.Action) != 1 ||
+		statement.Resource[0] != "arn:aws:s3:::assets/*" || statement.Condition["StringEquals"]["s3:ExistingObjectTag/public"][0] != "true" {
+		t.Fatalf("wrong statement: %+v", statement)
+	}
+
+	// elements without a field of their own are written back unchanged
+	document := `{"Version":"2012-10-17","Id":"policy-1","Statement":[` +
+		`{"Effect":"Deny","NotPrincipal":{"Service":["logging.s3.amazonaws.com"]},"NotAction":["s3:GetObject"],` +
+		`"NotResource":["arn:aws:s3:::assets/public/*"],"Custom":{"key":"value"}},` +
+		`{"Effect":"Allow","Principal":{"Federated":["cognito-identity.amazonaws.com"]},"Action":["s3:PutObject"],"Resource":["arn:aws:s3:::assets/*"]}]}`
+	if template, err = store.PolicyFromJSON(document); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = template("assets"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got any
+	if err := json.Unmarshal([]byte(document), &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("wrong policy:\n%s\nwant:\n%s", data, document)
+	}
+}
+
+func TestDataStorePolicyReconcile(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// setup and init store with public listing
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithBucketPolicy(store.PolicyPublicReadList()),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("policy-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("a")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same policy written in another order and with single values by
+	// another tool is not changed
+	fake.setPolicy("policy-bucket", `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::policy-bucket/*"},
+		{"Effect":"Allow","Principal":{"AWS":"*"},"Action":["s3:ListBucket","s3:GetBucketLocation"],"Resource":["arn:aws:s3:::policy-bucket"]}
+	]}`)
+	changed, err := dataStore.ReconcileBucketPolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 0 {
+		t.Fatalf("wrong number of changed buckets: %d", changed)
+	}
+
+	// statements for public objects are kept, others are replaced
+	fake.setPolicy("policy-bucket", `{"Version":"2012-10-17","Statement":[
+		{"Sid":"comby-public-object-tag","Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::policy-bucket/*",
+			"Condition":{"StringEquals":{"s3:ExistingObjectTag/public":"true"}}},
+		{"Sid":"other","Effect":"Allow","Principal":"*","Action":"s3:PutObject","Resource":"arn:aws:s3:::policy-bucket/*"}
+	]}`)
+	if changed, err = dataStore.ReconcileBucketPolicies(ctx); err != nil || changed != 1 {
+		t.Fatalf("wrong number of changed buckets: %d, %v", changed, err)
+	}
+	policy, err := dataStore.GetBucketPolicy(ctx, "policy-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sids := map[string]bool{}
+	for _, statement := range policy.Statement {
+		sids[statement.Sid] = true
+	}
+	if len(policy.Statement) != 3 || !sids["comby-public-object-tag"] || sids["other"] {
+		t.Fatalf("wrong policy: %+v", policy)
+	}
+
+	// buckets of leases are left alone
+	if _, err = dataStore.AcquireLease(ctx, "lease-bucket", "lock", "owner", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	leasePolicy := `{"Version":"2012-10-17","Statement":[]}`
+	fake.setPolicy("lease-bucket", leasePolicy)
+	if changed, err = dataStore.ReconcileBucketPolicies(ctx); err != nil || changed != 0 {
+		t.Fatalf("wrong number of changed buckets: %d, %v", changed, err)
+	}
+
+	// without a configured policy nothing is changed
+	plainStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err = plainStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err = plainStore.ReconcileBucketPolicies(ctx); err != nil || changed != 0 {
+		t.Fatalf("wrong number of changed buckets: %d, %v", changed, err)
+	}
+	if policy, err = plainStore.GetBucketPolicy(ctx, "policy-bucket"); err != nil || len(policy.Statement) != 3 {
+		t.Fatalf("expected policy to be kept: %+v, %v", policy, err)
+	}
+}
+
+func TestDataStorePolicy(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store with public avatars
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithBucketPolicy(store.PolicyPublicRead("avatars/")),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set creates the bucket with the configured policy
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("policy-bucket"),
+		comby.DataStoreSetOptionWithObjectName("avatars/a"),
+		comby.DataStoreSetOptionWithData([]byte("a")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := dataStore.GetBucketPolicy(ctx, "policy-bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy == nil || len(policy.Statement) != 1 || policy.Statement[0].Resource[0] != "arn:aws:s3:::policy-bucket/avatars/*" {
+		t.Fatalf("wrong policy: %+v", policy)
+	}
+
+	// make the bucket private again
+	if err := dataStore.SetBucketPolicy(ctx, "policy-bucket", nil); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err = dataStore.GetBucketPolicy(ctx, "policy-bucket"); err != nil || policy != nil {
+		t.Fatalf("expected no policy: %+v, %v", policy, err)
+	}
+
+	// reconcile restores the configured policy, once
+	changed, err := dataStore.ReconcileBucketPolicies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 1 {
+		t.Fatalf("wrong number of changed buckets: %d", changed)
+	}
+	if policy, err = dataStore.GetBucketPolicy(ctx, "policy-bucket"); err != nil || policy == nil {
+		t.Fatalf("expected policy: %+v, %v", policy, err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
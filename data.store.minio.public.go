@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// PUBLIC_OBJECT_TAG is the object tag (with value "true") marking an object
+// as public, see DataStoreSetOptionWithPublic.
+const PUBLIC_OBJECT_TAG = "public"
+
+// Statement ids of the policy statements managed for public objects.
+const (
+	policySidPublicPrefix    = "comby-public-prefix"
+	policySidPublicObjectTag = "comby-public-object-tag"
+)
+
+// DataStoreOptionWithPublicPrefix makes all objects below the prefix public.
+// The bucket policy is extended as soon as such an object is written.
+func DataStoreOptionWithPublicPrefix(prefix string) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_PUBLIC_PREFIX, prefix)
+}
+
+// DataStoreSetOptionWithPublic makes the written object public by tagging it
+// with PUBLIC_OBJECT_TAG. The bucket policy is extended to allow anonymous
+// reads of tagged objects. Note that encrypted objects are published as
+// stored, i.e. encrypted.
+func DataStoreSetOptionWithPublic(public bool) comby.DataStoreSetOption {
+	return func(opt *comby.DataStoreSetOptions) (*comby.DataStoreSetOptions, error) {
+		opt.Attributes.Set(DATA_STORE_ATTRIBUTE_PUBLIC_OBJECT, public)
+		return opt, nil
+	}
+}
+
+// PublicURL returns the direct URL of an object. Anonymous requests only
+// succeed for public objects.
+func (dsm *dataStoreMinio) PublicURL(bucketName, objectName string) string {
+	u := *dsm.minioClient.EndpointURL()
+	u.Path = "/" + bucketName + "/" + objectName
+	return u.String()
+}
+
+// applyPublicObject tags a public object and makes sure the bucket policy
+// allows anonymous reads of it.
+func (dsm *dataStoreMinio) applyPublicObject(ctx context.Context, bucketName, objectName string, attributes *comby.Attributes, opts *minio.PutObjectOptions) error {
+	public, _ := attributeValue[bool](attributes, DATA_STORE_ATTRIBUTE_PUBLIC_OBJECT)
+	if public {
+		if opts.UserTags == nil {
+			opts.UserTags = map[string]string{}
+		}
+		opts.UserTags[PUBLIC_OBJECT_TAG] = "true"
+	}
+	prefix := dsm.publicPrefix()
+	if !public && (prefix == "" || !strings.HasPrefix(objectName, prefix)) {
+		return nil
+	}
+	return dsm.ensurePublicAccess(ctx, bucketName)
+}
+
+// ensurePublicAccess adds the statements for public objects to the bucket
+// policy unless they are present already. Checked buckets are remembered
+// until the bucket is created again.
+func (dsm *dataStoreMinio) ensurePublicAccess(ctx context.Context, bucketName string) error {
+	if _, ok := dsm.publicBuckets.Load(bucketName); ok {
+		return nil
+	}
+	policy, err := dsm.GetBucketPolicy(ctx, bucketName)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		policy = &BucketPolicy{Version: policyVersion}
+	}
+	sids := make(map[string]bool)
+	for _, statement := range policy.Statement {
+		sids[statement.Sid] = true
+	}
+	changed := false
+	for _, statement := range publicObjectStatements(bucketName, dsm.publicPrefix()) {
+		if !sids[statement.Sid] {
+			policy.Statement = append(policy.Statement, statement)
+			changed = true
+		}
+	}
+	if changed {
+		if err := dsm.SetBucketPolicy(ctx, bucketName, policy); err != nil {
+			return fmt.Errorf("failed to allow public objects: %w", err)
+		}
+		dsm.logger().Info("bucket policy extended for public objects", "bucket", bucketName)
+	}
+	dsm.publicBuckets.Store(bucketName, struct{}{})
+	return nil
+}
+
+func (dsm *dataStoreMinio) publicPrefix() string {
+	prefix, _ := attributeValue[string](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_PUBLIC_PREFIX)
+	return prefix
+}
+
+// withPublicObjectStatements returns policy extended by the statements for
+// public objects found in current, if it lacks them.
+func withPublicObjectStatements(policy, current *BucketPolicy) *BucketPolicy {
+	if current == nil {
+		return policy
+	}
+	sids := make(map[string]bool)
+	if policy != nil {
+		for _, statement := range policy.Statement {
+			sids[statement.Sid] = true
+		}
+	}
+	var statements []PolicyStatement
+	for _, statement := range current.Statement {
+		if (statement.Sid == policySidPublicObjectTag || statement.Sid == policySidPublicPrefix) && !sids[statement.Sid] {
+			statements = append(statements, statement)
+		}
+	}
+	if len(statements) == 0 {
+		return policy
+	}
+	merged := &BucketPolicy{Version: policyVersion}
+	if policy != nil {
+		merged.Version, merged.ID = policy.Version, policy.ID
+		merged.Statement = append(merged.Statement, policy.Statement...)
+	}
+	merged.Statement = append(merged.Statement, statements...)
+	return merged
+}
+
+// publicObjectStatements allows anonymous reads of tagged objects and of the
+// objects below prefix, if any.
+func publicObjectStatements(bucketName, prefix string) []PolicyStatement {
+	tagStatement := publicGetStatement(bucketName, nil)
+	tagStatement.Sid = policySidPublicObjectTag
+	tagStatement.Condition = map[string]map[string]PolicyStrings{
+		"StringEquals": {"s3:ExistingObjectTag/" + PUBLIC_OBJECT_TAG: {"true"}},
+	}
+	statements := []PolicyStatement{tagStatement}
+	if prefix != "" {
+		prefixStatement := publicGetStatement(bucketName, []string{prefix})
+		prefixStatement.Sid = policySidPublicPrefix
+		statements = append(statements, prefixStatement)
+	}
+	return statements
+}
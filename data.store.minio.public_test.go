@@ -0,0 +1,78 @@
+package store_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStorePublicObjects(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store with a public prefix
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithPublicPrefix("avatars/"),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// objects below the public prefix, tagged objects and private objects
+	for _, object := range []struct {
+		name   string
+		public bool
+	}{
+		{"avatars/alice", false},
+		{"logos/company", true},
+		{"invoices/2024", false},
+	} {
+		if err := dataStore.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("public-bucket"),
+			comby.DataStoreSetOptionWithObjectName(object.name),
+			comby.DataStoreSetOptionWithData([]byte(object.name)),
+			store.DataStoreSetOptionWithPublic(object.public),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// anonymous reads
+	for name, wantStatus := range map[string]int{
+		"avatars/alice": http.StatusOK,
+		"logos/company": http.StatusOK,
+		"invoices/2024": http.StatusForbidden,
+	} {
+		resp, err := http.Get(dataStore.PublicURL("public-bucket", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			t.Fatalf("%s: wrong status %d", name, resp.StatusCode)
+		}
+		if wantStatus == http.StatusOK && string(data) != name {
+			t.Fatalf("%s: wrong data %q", name, data)
+		}
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,311 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// defaultResetAllowlist are the endpoint hosts on which Reset is allowed
+// without DataStoreOptionWithAllowReset.
+var defaultResetAllowlist = []string{"localhost", "127.0.0.1", "::1"}
+
+// ResetPlan reports what Reset would remove.
+type ResetPlan struct {
+	Buckets          []*ResetPlanBucket
+	NumObjects       int64
+	TotalSizeInBytes int64
+}
+
+// ResetPlanBucket reports what Reset would remove from a single bucket.
+type ResetPlanBucket struct {
+	BucketName       string
+	NumObjects       int64
+	NumDeleteMarkers int64
+	SizeInBytes      int64
+}
+
+// BucketError reports a bucket that could not be removed.
+type BucketError struct {
+	BucketName string
+	Err        error
+}
+
+func (e *BucketError) Error() string {
+	return fmt.Sprintf("failed to remove bucket %s: %v", e.BucketName, e.Err)
+}
+
+func (e *BucketError) Unwrap() error {
+	return e.Err
+}
+
+// ResetResult reports what a reset removed and what it left behind.
+type ResetResult struct {
+	RemovedBuckets       []string
+	RemovedObjects       int
+	RemovedDeleteMarkers int
+	// LockedObjects are object versions kept because of compliance retention,
+	// governance retention without bypass, or a legal hold.
+	LockedObjects []*DeleteError
+	// FailedObjects are object versions that could not be listed or removed
+	// for other reasons.
+	FailedObjects []*DeleteError
+	// FailedBuckets are buckets that could not be removed, usually because
+	// they still contain locked objects.
+	FailedBuckets []*BucketError
+}
+
+// ResetError is returned by Reset if not everything could be removed.
+type ResetError struct {
+	Result *ResetResult
+}
+
+func (e *ResetError) Error() string {
+	errs := e.Unwrap()
+	return fmt.Sprintf("reset completed with %d errors: %v", len(errs), errs)
+}
+
+// Unwrap returns all errors of the reset, so errors.Is(err, ErrObjectLocked)
+// tells whether locked objects were left behind.
+func (e *ResetError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Result.LockedObjects {
+		errs = append(errs, err)
+	}
+	for _, err := range e.Result.FailedObjects {
+		errs = append(errs, err)
+	}
+	for _, err := range e.Result.FailedBuckets {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// ResetWithResult removes all object versions, delete markers and buckets,
+// except the buckets of audit sinks (see NewAuditSinkBucket).
+// Objects are removed with multi-object delete requests while they are
+// listed, several buckets are processed in parallel (see
+// DataStoreOptionWithDeleteConcurrency). A cancelled context stops the reset
+// and returns the context error together with the partial result.
+// Object versions under governance retention are removed if the store was
+// configured with DataStoreOptionWithResetGovernanceBypass, versions under
+// compliance retention or legal hold are skipped and reported, and so are
+// the buckets containing them. If anything is left behind, a *ResetError is
+// returned together with the result.
+func (dsm *dataStoreMinio) ResetWithResult(ctx context.Context) (result *ResetResult, err error) {
+	result = &ResetResult{}
+	if dsm.minioClient == nil {
+		return result, nil
+	}
+	record := dsm.newAuditRecord(ctx, "Reset", "", "")
+	defer func() {
+		record.Objects = int64(result.RemovedObjects)
+		dsm.audit(ctx, record, err)
+	}()
+	if err = dsm.checkResetAllowed(); err != nil {
+		return result, err
+	}
+	buckets, err := dsm.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return result, err
+	}
+	bucketNames := dsm.withoutAuditBuckets(buckets)
+	dsm.logResetAudit("minio reset started", "numBuckets", len(bucketNames))
+	if dsm.cache != nil {
+		dsm.cache.clear()
+	}
+	governanceBypass, _ := attributeValue[bool](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_RESET_GOVERNANCE_BYPASS)
+
+	removeOpts := minio.RemoveObjectsOptions{
+		GovernanceBypass: governanceBypass,
+	}
+	var mu sync.Mutex
+	dsm.forEachBucket(ctx, bucketNames, func(bucketName string) {
+		// First, remove all objects, their versions and delete markers in
+		// batches while they are listed
+		var listErrs []*DeleteError
+		deleteMarkers := make(map[string]bool)
+		objectsCh := make(chan minio.ObjectInfo)
+		go func() {
+			defer close(objectsCh)
+			listCh := dsm.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+				Recursive:    true,
+				WithVersions: true,
+			})
+			for object := range listCh {
+				if object.Err != nil {
+					listErrs = append(listErrs, &DeleteError{
+						BucketName: bucketName,
+						Err:        fmt.Errorf("failed to list objects: %w", object.Err),
+					})
+					continue
+				}
+				if object.IsDeleteMarker {
+					deleteMarkers[object.VersionID] = true
+				}
+				select {
+				case objectsCh <- object:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		removed, errs := dsm.removeObjects(ctx, bucketName, objectsCh, removeOpts)
+
+		// count what was removed, not what was listed, a cancelled reset
+		// stops in between
+		removedObjects, removedDeleteMarkers := 0, 0
+		for _, result := range removed {
+			if result.DeleteMarker || deleteMarkers[result.ObjectVersionID] {
+				removedDeleteMarkers++
+			} else {
+				removedObjects++
+			}
+		}
+		var lockedObjects, failedObjects []*DeleteError
+		for _, deleteErr := range errs {
+			if errors.Is(deleteErr.Err, ErrObjectLocked) {
+				lockedObjects = append(lockedObjects, deleteErr)
+			} else {
+				failedObjects = append(failedObjects, deleteErr)
+			}
+		}
+
+		// Then, remove the bucket itself
+		bucketErr := ctx.Err()
+		if bucketErr == nil {
+			if err := dsm.minioClient.RemoveBucket(ctx, bucketName); err != nil {
+				if len(lockedObjects) > 0 {
+					err = fmt.Errorf("%d locked object versions left: %w", len(lockedObjects), err)
+				}
+				bucketErr = err
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		result.RemovedObjects += removedObjects
+		result.RemovedDeleteMarkers += removedDeleteMarkers
+		result.LockedObjects = append(result.LockedObjects, lockedObjects...)
+		result.FailedObjects = append(result.FailedObjects, listErrs...)
+		result.FailedObjects = append(result.FailedObjects, failedObjects...)
+		if bucketErr != nil {
+			result.FailedBuckets = append(result.FailedBuckets, &BucketError{BucketName: bucketName, Err: bucketErr})
+			return
+		}
+		result.RemovedBuckets = append(result.RemovedBuckets, bucketName)
+	})
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	dsm.logResetAudit("minio reset finished",
+		"removedBuckets", len(result.RemovedBuckets),
+		"removedObjects", result.RemovedObjects,
+		"removedDeleteMarkers", result.RemovedDeleteMarkers,
+		"lockedObjects", len(result.LockedObjects),
+		"failedObjects", len(result.FailedObjects),
+		"failedBuckets", len(result.FailedBuckets),
+	)
+	if len(result.LockedObjects) > 0 || len(result.FailedObjects) > 0 || len(result.FailedBuckets) > 0 {
+		return result, &ResetError{Result: result}
+	}
+	return result, nil
+}
+
+// ResetDryRun lists what Reset would remove: every bucket but the audit
+// buckets with the number of object versions, delete markers and their
+// size. Nothing is removed and the reset guard is not checked.
+func (dsm *dataStoreMinio) ResetDryRun(ctx context.Context) (*ResetPlan, error) {
+	plan := &ResetPlan{}
+	if dsm.minioClient == nil {
+		return plan, nil
+	}
+	buckets, err := dsm.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return plan, err
+	}
+	for _, bucketName := range dsm.withoutAuditBuckets(buckets) {
+		planBucket := &ResetPlanBucket{BucketName: bucketName}
+		objectCh := dsm.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+			Recursive:    true,
+			WithVersions: true,
+		})
+		for object := range objectCh {
+			if object.Err != nil {
+				return plan, fmt.Errorf("failed to list objects in bucket %s: %w", bucketName, object.Err)
+			}
+			if object.IsDeleteMarker {
+				planBucket.NumDeleteMarkers++
+				continue
+			}
+			planBucket.NumObjects++
+			planBucket.SizeInBytes += object.Size
+		}
+		plan.Buckets = append(plan.Buckets, planBucket)
+		plan.NumObjects += planBucket.NumObjects
+		plan.TotalSizeInBytes += planBucket.SizeInBytes
+	}
+	return plan, nil
+}
+
+// checkResetAllowed returns ErrResetNotAllowed unless resetting was allowed
+// explicitly or the hosts of the endpoint and of all additional endpoints
+// (see DataStoreOptionWithEndpoints) match the reset allowlist.
+func (dsm *dataStoreMinio) checkResetAllowed() error {
+	if allowed, _ := attributeValue[bool](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_ALLOW_RESET); allowed {
+		return nil
+	}
+	allowlist := defaultResetAllowlist
+	if patterns, ok := attributeValue[[]string](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_RESET_ALLOWLIST); ok {
+		allowlist = patterns
+	}
+	endpoints, _ := attributeValue[[]string](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_ENDPOINTS)
+	for _, endpoint := range append([]string{dsm.Endpoint}, endpoints...) {
+		if !resetAllowedFor(endpoint, allowlist) {
+			dsm.logResetAudit("minio reset refused", "endpoint", endpoint)
+			return fmt.Errorf("'%s': %w for %s, use DataStoreOptionWithAllowReset or DataStoreOptionWithResetAllowlist", dsm.String(), ErrResetNotAllowed, endpoint)
+		}
+	}
+	return nil
+}
+
+// resetAllowedFor reports whether the host of endpoint matches the allowlist.
+func resetAllowedFor(endpoint string, allowlist []string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = endpoint
+	}
+	for _, pattern := range allowlist {
+		if matched, _ := path.Match(pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// logResetAudit writes an audit entry about a reset with the endpoint, the
+// local user and host, and the time.
+func (dsm *dataStoreMinio) logResetAudit(msg string, args ...any) {
+	actor := ""
+	if u, err := user.Current(); err == nil {
+		actor = u.Username
+	}
+	hostname, _ := os.Hostname()
+	args = append([]any{
+		"store", dsm.String(),
+		"connection", dsm.dataStoreInfoModel.ConnectionInfo,
+		"actor", actor,
+		"hostname", hostname,
+		"time", time.Now().UTC().Format(time.RFC3339),
+	}, args...)
+	dsm.logger().Warn(msg, args...)
+}
@@ -0,0 +1,229 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreResetLocked(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store with locked buckets and governance bypass
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		withObjectLocking,
+		store.DataStoreOptionWithResetGovernanceBypass(true),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set one object under governance retention and one under legal hold
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("reset-governance"),
+		comby.DataStoreSetOptionWithObjectName("object"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData([]byte("governance")),
+		store.DataStoreSetOptionWithRetention(store.RETENTION_MODE_GOVERNANCE, time.Now().Add(time.Hour)),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("reset-legal-hold"),
+		comby.DataStoreSetOptionWithObjectName("object"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData([]byte("legal hold")),
+		store.DataStoreSetOptionWithLegalHold(true),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reset removes the governance bucket and reports the legal hold bucket
+	result, err := dataStore.ResetWithResult(ctx)
+	var resetErr *store.ResetError
+	if !errors.As(err, &resetErr) {
+		t.Fatalf("expected reset error, got: %v", err)
+	}
+	if !errors.Is(err, store.ErrObjectLocked) {
+		t.Fatalf("expected object locked error, got: %v", err)
+	}
+	if len(result.RemovedBuckets) != 1 || result.RemovedBuckets[0] != "reset-governance" {
+		t.Fatalf("wrong removed buckets: %v", result.RemovedBuckets)
+	}
+	if len(result.LockedObjects) != 1 || len(result.FailedBuckets) != 1 {
+		t.Fatalf("wrong locked objects %v or failed buckets %v", result.LockedObjects, result.FailedBuckets)
+	}
+	if result.FailedBuckets[0].BucketName != "reset-legal-hold" {
+		t.Fatalf("wrong failed bucket: %s", result.FailedBuckets[0].BucketName)
+	}
+
+	// Release the legal hold, then reset succeeds
+	if err := dataStore.SetLegalHold(ctx, false,
+		comby.DataStoreGetOptionWithBucketName("reset-legal-hold"),
+		comby.DataStoreGetOptionWithObjectName("object"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
+
+func TestDataStoreResetGuard(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// remote endpoints are protected by default
+	dataStore := store.NewDataStoreMinio("minio.example.com:9000", true, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Reset(ctx); !errors.Is(err, store.ErrResetNotAllowed) {
+		t.Fatalf("expected reset not allowed error, got: %v", err)
+	}
+
+	// the allowlist replaces the default
+	dataStore = store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithResetAllowlist("*.dev.example.com"),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Reset(ctx); !errors.Is(err, store.ErrResetNotAllowed) {
+		t.Fatalf("expected reset not allowed error, got: %v", err)
+	}
+
+	// so are further endpoints of a local one
+	dataStore = store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithEndpoints("minio.example.com:9000"),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer dataStore.Close(ctx)
+	if err := dataStore.Reset(ctx); !errors.Is(err, store.ErrResetNotAllowed) {
+		t.Fatalf("expected reset not allowed error, got: %v", err)
+	}
+}
+
+func TestDataStoreResetDryRun(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	testData := []byte("dry run")
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("dry-run-bucket"),
+		comby.DataStoreSetOptionWithObjectName("object"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData(testData),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dry run reports without removing
+	if plan, err := dataStore.ResetDryRun(ctx); err != nil {
+		t.Fatal(err)
+	} else {
+		if len(plan.Buckets) != 1 || plan.NumObjects != 1 {
+			t.Fatalf("wrong plan: %d buckets, %d objects", len(plan.Buckets), plan.NumObjects)
+		}
+		if plan.TotalSizeInBytes != int64(len(testData)) {
+			t.Fatalf("wrong plan size: %d", plan.TotalSizeInBytes)
+		}
+	}
+	if dataStore.Total(ctx) != 1 {
+		t.Fatalf("wrong total after dry run: %d", dataStore.Total(ctx))
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
+
+func TestDataStoreResetParallel(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithDeleteConcurrency(3),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set values in several buckets
+	for _, bucketName := range []string{"parallel1", "parallel2", "parallel3", "parallel4"} {
+		for _, objectName := range []string{"a", "b", "c/d"} {
+			if err := dataStore.Set(ctx,
+				comby.DataStoreSetOptionWithBucketName(bucketName),
+				comby.DataStoreSetOptionWithObjectName(objectName),
+				comby.DataStoreSetOptionWithContentType("text/plain"),
+				comby.DataStoreSetOptionWithData([]byte(objectName)),
+			); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Reset removes everything
+	if result, err := dataStore.ResetWithResult(ctx); err != nil {
+		t.Fatal(err)
+	} else {
+		if len(result.RemovedBuckets) != 4 || result.RemovedObjects != 12 {
+			t.Fatalf("wrong result: %d buckets, %d objects", len(result.RemovedBuckets), result.RemovedObjects)
+		}
+	}
+	if dataStore.Total(ctx) != 0 {
+		t.Fatalf("wrong total after reset: %d", dataStore.Total(ctx))
+	}
+
+	// A cancelled context stops the reset
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := dataStore.Reset(cancelledCtx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got: %v", err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
@@ -0,0 +1,543 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// Values for ShardOptions.ShardBy.
+const (
+	// SHARD_BY_OBJECT distributes the objects of a bucket over all shards.
+	SHARD_BY_OBJECT = "object"
+	// SHARD_BY_BUCKET keeps all objects of a bucket on the same shard.
+	SHARD_BY_BUCKET = "bucket"
+)
+
+// ShardOptions configure a sharded store.
+type ShardOptions struct {
+	ShardBy string
+	// VirtualNodes is the number of points per shard on the hash ring. More
+	// points spread the objects more evenly.
+	VirtualNodes int
+}
+
+type ShardOption func(opt *ShardOptions) (*ShardOptions, error)
+
+// ShardOptionWithShardBy sets SHARD_BY_OBJECT (default) or SHARD_BY_BUCKET.
+func ShardOptionWithShardBy(shardBy string) ShardOption {
+	return func(opt *ShardOptions) (*ShardOptions, error) {
+		if shardBy != SHARD_BY_OBJECT && shardBy != SHARD_BY_BUCKET {
+			return nil, fmt.Errorf("invalid shard key %q", shardBy)
+		}
+		opt.ShardBy = shardBy
+		return opt, nil
+	}
+}
+
+// ShardOptionWithVirtualNodes sets the number of points per shard on the
+// hash ring.
+func ShardOptionWithVirtualNodes(virtualNodes int) ShardOption {
+	return func(opt *ShardOptions) (*ShardOptions, error) {
+		if virtualNodes <= 0 {
+			return nil, fmt.Errorf("invalid number of virtual nodes %d", virtualNodes)
+		}
+		opt.VirtualNodes = virtualNodes
+		return opt, nil
+	}
+}
+
+// ShardMove is an object stored on another shard than the one it belongs to.
+type ShardMove struct {
+	BucketName string
+	ObjectName string
+	From       string
+	To         string
+	// Stale is set if the shard already held a newer version of the object,
+	// so the old copy was only removed.
+	Stale bool
+}
+
+// ShardMoveError reports an object that could not be moved to its shard.
+type ShardMoveError struct {
+	*ShardMove
+	Err error
+}
+
+func (e *ShardMoveError) Error() string {
+	return fmt.Sprintf("failed to move object %s/%s from %s to %s: %v", e.BucketName, e.ObjectName, e.From, e.To, e.Err)
+}
+
+func (e *ShardMoveError) Unwrap() error {
+	return e.Err
+}
+
+// RebalanceResult summarizes a rebalancing.
+type RebalanceResult struct {
+	// Checked is the number of objects on all shards.
+	Checked int
+	// Moves are the objects moved to their shard, or to be moved by a dry
+	// run.
+	Moves []*ShardMove
+	// Errors holds one entry per object that could not be moved.
+	Errors []*ShardMoveError
+}
+
+// DataStoreSharded is a comby.DataStore distributing buckets or objects over
+// several MinIO stores by consistent hashing.
+type DataStoreSharded interface {
+	comby.DataStore
+
+	// Shard returns the shard an object belongs to.
+	Shard(bucketName, objectName string) comby.DataStore
+	// Rebalance moves all objects stored on another shard than the one they
+	// belong to, e.g. after a shard was added.
+	Rebalance(ctx context.Context) (*RebalanceResult, error)
+	// RebalanceDryRun reports what Rebalance would move without moving
+	// anything.
+	RebalanceDryRun(ctx context.Context) (*RebalanceResult, error)
+}
+
+type dataStoreSharded struct {
+	shards  []*dataStoreMinio
+	options ShardOptions
+	ring    *hashRing
+
+	dataStoreInfoModel *comby.DataStoreInfoModel
+}
+
+// Make sure it implements interfaces
+var _ comby.DataStore = (*dataStoreSharded)(nil)
+var _ DataStoreSharded = (*dataStoreSharded)(nil)
+
+// NewDataStoreSharded returns a store distributing objects over the given
+// shards. All of them must have been created with NewDataStoreMinio on
+// distinct endpoints and are initialized by Init of the sharded store.
+// Shards are identified by their endpoint, so their order does not matter
+// and adding a shard only moves the objects the new shard takes over.
+func NewDataStoreSharded(shards []comby.DataStore, opts ...ShardOption) (DataStoreSharded, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards given")
+	}
+	dss := &dataStoreSharded{
+		options: ShardOptions{
+			ShardBy:      SHARD_BY_OBJECT,
+			VirtualNodes: 128,
+		},
+		dataStoreInfoModel: &comby.DataStoreInfoModel{
+			StoreType: "minio-sharded",
+		},
+	}
+	for _, opt := range opts {
+		if _, err := opt(&dss.options); err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		shardStore, ok := shard.(*dataStoreMinio)
+		if !ok {
+			return nil, fmt.Errorf("shard '%s' is not a minio data store", shard.String())
+		}
+		dss.shards = append(dss.shards, shardStore)
+		names = append(names, shardStore.String())
+	}
+	ring, err := newHashRing(names, dss.options.VirtualNodes)
+	if err != nil {
+		return nil, err
+	}
+	dss.ring = ring
+	return dss, nil
+}
+
+func (dss *dataStoreSharded) Init(ctx context.Context, opts ...comby.DataStoreOption) error {
+	connectionInfos := make([]string, 0, len(dss.shards))
+	for _, shard := range dss.shards {
+		if err := shard.Init(ctx, opts...); err != nil {
+			return err
+		}
+		connectionInfos = append(connectionInfos, shard.dataStoreInfoModel.ConnectionInfo)
+	}
+	dss.dataStoreInfoModel.ConnectionInfo = strings.Join(connectionInfos, "; ")
+	return nil
+}
+
+func (dss *dataStoreSharded) Get(ctx context.Context, opts ...comby.DataStoreGetOption) (*comby.DataModel, error) {
+	getOpts := comby.DataStoreGetOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&getOpts); err != nil {
+			return nil, err
+		}
+	}
+	owner := dss.shardOf(getOpts.BucketName, getOpts.ObjectName)
+	model, err := owner.Get(ctx, opts...)
+	if err == nil || !isNotFound(err) {
+		return model, err
+	}
+
+	// the object may not have been moved to its shard yet
+	for _, shard := range dss.shards {
+		if shard == owner {
+			continue
+		}
+		if otherModel, otherErr := shard.Get(ctx, opts...); otherErr == nil {
+			return otherModel, nil
+		}
+	}
+	return model, err
+}
+
+func (dss *dataStoreSharded) Set(ctx context.Context, opts ...comby.DataStoreSetOption) error {
+	setOpts := comby.DataStoreSetOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&setOpts); err != nil {
+			return err
+		}
+	}
+	return dss.shardOf(setOpts.BucketName, setOpts.ObjectName).Set(ctx, opts...)
+}
+
+// Copy copies server-side if source and destination belong to the same
+// shard, otherwise the object is transferred with CopyAcross. A source not
+// moved to its shard yet is copied from the shard still holding it.
+func (dss *dataStoreSharded) Copy(ctx context.Context, opts ...comby.DataStoreCopyOption) error {
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return err
+		}
+	}
+	owner := dss.shardOf(copyOpts.SrcBucketName, copyOpts.SrcObjectName)
+	dst := dss.shardOf(copyOpts.DstBucketName, copyOpts.DstObjectName)
+	err := copyBetween(ctx, owner, dst, opts...)
+	if err == nil {
+		return nil
+	}
+
+	// the source may not have been moved to its shard yet
+	if _, statErr := owner.minioClient.StatObject(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName, minio.StatObjectOptions{}); !isNotFound(statErr) {
+		return err
+	}
+	for _, shard := range dss.shards {
+		if shard == owner {
+			continue
+		}
+		if _, statErr := shard.minioClient.StatObject(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName, minio.StatObjectOptions{}); statErr == nil {
+			return copyBetween(ctx, shard, dst, opts...)
+		}
+	}
+	return err
+}
+
+// copyBetween copies server-side within a shard and with CopyAcross between
+// shards.
+func copyBetween(ctx context.Context, src, dst *dataStoreMinio, opts ...comby.DataStoreCopyOption) error {
+	if src == dst {
+		return src.Copy(ctx, opts...)
+	}
+	return CopyAcross(ctx, src, dst, opts...)
+}
+
+// List lists the objects of all shards sorted by bucket and object name.
+func (dss *dataStoreSharded) List(ctx context.Context, opts ...comby.DataStoreListOption) ([]*comby.DataModel, int64, error) {
+	var items []*comby.DataModel
+	seen := make(map[string]bool)
+	for _, shard := range dss.shards {
+		shardItems, _, err := shard.List(ctx, opts...)
+		if err != nil {
+			return items, int64(len(items)), fmt.Errorf("'%s': %w", shard.String(), err)
+		}
+		for _, item := range shardItems {
+			// objects are on two shards while they are moved
+			if key := cacheKey(item.BucketName, item.ObjectName); !seen[key] {
+				seen[key] = true
+				items = append(items, item)
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].BucketName != items[j].BucketName {
+			return items[i].BucketName < items[j].BucketName
+		}
+		return items[i].ObjectName < items[j].ObjectName
+	})
+	return items, int64(len(items)), nil
+}
+
+// Delete removes the object from its shard and from all other shards. A
+// copy not moved yet by Rebalance would otherwise still be found by Get and
+// moved back later.
+func (dss *dataStoreSharded) Delete(ctx context.Context, opts ...comby.DataStoreDeleteOption) error {
+	deleteOpts := comby.DataStoreDeleteOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&deleteOpts); err != nil {
+			return err
+		}
+	}
+	owner := dss.shardOf(deleteOpts.BucketName, deleteOpts.ObjectName)
+	if err := owner.Delete(ctx, opts...); err != nil {
+		return err
+	}
+	var errs []error
+	for _, shard := range dss.shards {
+		if shard == owner {
+			continue
+		}
+		if err := shard.Delete(ctx, opts...); err != nil && !isNotFound(err) {
+			errs = append(errs, fmt.Errorf("'%s': %w", shard.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (dss *dataStoreSharded) Total(ctx context.Context) int64 {
+	total := int64(0)
+	for _, shard := range dss.shards {
+		total += shard.Total(ctx)
+	}
+	return total
+}
+
+func (dss *dataStoreSharded) Close(ctx context.Context) error {
+	var errs []error
+	for _, shard := range dss.shards {
+		errs = append(errs, shard.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func (dss *dataStoreSharded) Options() comby.DataStoreOptions {
+	return dss.shards[0].Options()
+}
+
+func (dss *dataStoreSharded) String() string {
+	names := make([]string, 0, len(dss.shards))
+	for _, shard := range dss.shards {
+		names = append(names, shard.Endpoint)
+	}
+	return "sharded://" + strings.Join(names, ",")
+}
+
+// Info sums up the info of all shards. Buckets present on several shards
+// are counted once.
+func (dss *dataStoreSharded) Info(ctx context.Context) (*comby.DataStoreInfoModel, error) {
+	dss.dataStoreInfoModel.LastUpdateTime = 0
+	dss.dataStoreInfoModel.NumBuckets = 0
+	dss.dataStoreInfoModel.NumObjects = 0
+	dss.dataStoreInfoModel.TotalSizeInBytes = 0
+
+	bucketNames := make(map[string]bool)
+	for _, shard := range dss.shards {
+		shardInfo, err := shard.Info(ctx)
+		if err != nil {
+			return dss.dataStoreInfoModel, fmt.Errorf("'%s': %w", shard.String(), err)
+		}
+		dss.dataStoreInfoModel.NumObjects += shardInfo.NumObjects
+		dss.dataStoreInfoModel.TotalSizeInBytes += shardInfo.TotalSizeInBytes
+		dss.dataStoreInfoModel.LastUpdateTime = max(dss.dataStoreInfoModel.LastUpdateTime, shardInfo.LastUpdateTime)
+
+		buckets, err := shard.minioClient.ListBuckets(ctx)
+		if err != nil {
+			return dss.dataStoreInfoModel, fmt.Errorf("'%s': %w", shard.String(), err)
+		}
+		for _, bucket := range buckets {
+			bucketNames[bucket.Name] = true
+		}
+	}
+	dss.dataStoreInfoModel.NumBuckets = int64(len(bucketNames))
+	return dss.dataStoreInfoModel, nil
+}
+
+// Reset resets all shards, see the Reset of the MinIO store.
+func (dss *dataStoreSharded) Reset(ctx context.Context) error {
+	var errs []error
+	for _, shard := range dss.shards {
+		if err := shard.Reset(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("'%s': %w", shard.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (dss *dataStoreSharded) Shard(bucketName, objectName string) comby.DataStore {
+	return dss.shardOf(bucketName, objectName)
+}
+
+func (dss *dataStoreSharded) Rebalance(ctx context.Context) (*RebalanceResult, error) {
+	return dss.rebalance(ctx, false)
+}
+
+func (dss *dataStoreSharded) RebalanceDryRun(ctx context.Context) (*RebalanceResult, error) {
+	return dss.rebalance(ctx, true)
+}
+
+// rebalance lists all shards first and then moves the misplaced objects. A
+// move copies the object to its shard before removing it from the old one,
+// so it stays readable through Get all the time. An object written to its
+// shard after the topology changed is newer than the misplaced copy, which
+// is removed without overwriting it.
+func (dss *dataStoreSharded) rebalance(ctx context.Context, dryRun bool) (*RebalanceResult, error) {
+	plan := &RebalanceResult{}
+	for _, shard := range dss.shards {
+		buckets, err := shard.minioClient.ListBuckets(ctx)
+		if err != nil {
+			return plan, fmt.Errorf("'%s': %w", shard.String(), err)
+		}
+		for _, bucket := range buckets {
+			objectCh := shard.minioClient.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{
+				Recursive: true,
+			})
+			for object := range objectCh {
+				if object.Err != nil {
+					return plan, fmt.Errorf("failed to list objects in bucket %s of '%s': %w", bucket.Name, shard.String(), object.Err)
+				}
+				plan.Checked++
+				if owner := dss.shardOf(bucket.Name, object.Key); owner != shard {
+					plan.Moves = append(plan.Moves, &ShardMove{
+						BucketName: bucket.Name,
+						ObjectName: object.Key,
+						From:       shard.String(),
+						To:         owner.String(),
+					})
+				}
+			}
+		}
+	}
+	if dryRun {
+		return plan, nil
+	}
+
+	result := &RebalanceResult{Checked: plan.Checked}
+	for _, move := range plan.Moves {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := dss.move(ctx, move); err != nil {
+			result.Errors = append(result.Errors, &ShardMoveError{ShardMove: move, Err: err})
+			continue
+		}
+		result.Moves = append(result.Moves, move)
+	}
+	dss.shards[0].logger().Info("shards rebalanced", "store", dss.String(), "checked", result.Checked,
+		"moved", len(result.Moves), "failed", len(result.Errors))
+	return result, nil
+}
+
+func (dss *dataStoreSharded) move(ctx context.Context, move *ShardMove) error {
+	from, to := dss.shardByName(move.From), dss.shardByName(move.To)
+	err := CopyAcross(ctx, from, to,
+		comby.DataStoreCopyOptionWithSrcBucketName(move.BucketName),
+		comby.DataStoreCopyOptionWithSrcObjectName(move.ObjectName),
+		comby.DataStoreCopyOptionWithDstBucketName(move.BucketName),
+		comby.DataStoreCopyOptionWithDstObjectName(move.ObjectName),
+		DataStoreCopyOptionWithCreateOnly(),
+	)
+	if errors.Is(err, ErrPreconditionFailed) {
+		// the precondition also fails if the source changed while it was
+		// read, only an existing destination makes the source stale
+		if _, statErr := to.minioClient.StatObject(ctx, move.BucketName, move.ObjectName, minio.StatObjectOptions{}); statErr != nil {
+			return err
+		}
+		move.Stale = true
+	} else if err != nil {
+		return err
+	}
+	return from.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName(move.BucketName),
+		comby.DataStoreDeleteOptionWithObjectName(move.ObjectName),
+	)
+}
+
+func (dss *dataStoreSharded) shardByName(name string) *dataStoreMinio {
+	for _, shard := range dss.shards {
+		if shard.String() == name {
+			return shard
+		}
+	}
+	return nil
+}
+
+// shardOf returns the shard an object belongs to.
+func (dss *dataStoreSharded) shardOf(bucketName, objectName string) *dataStoreMinio {
+	key := bucketName
+	if dss.options.ShardBy == SHARD_BY_OBJECT {
+		key = cacheKey(bucketName, objectName)
+	}
+	return dss.shards[dss.ring.lookup(key)]
+}
+
+// isNotFound reports whether the bucket or object does not exist.
+func isNotFound(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return true
+	}
+	return false
+}
+
+// hashRing maps keys to nodes by consistent hashing. Each node owns the
+// keys hashing between the points of other nodes and its own points.
+type hashRing struct {
+	points []uint64
+	nodes  map[uint64]int
+}
+
+// newHashRing places virtualNodes points per node on the ring. Nodes are
+// identified by their index in names. Nodes are placed in name order, so
+// the ring does not depend on the order of names.
+func newHashRing(names []string, virtualNodes int) (*hashRing, error) {
+	ring := &hashRing{nodes: make(map[uint64]int)}
+	order := make([]int, len(names))
+	for node := range order {
+		order[node] = node
+	}
+	sort.Slice(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+	seen := make(map[string]bool)
+	for _, node := range order {
+		name := names[node]
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate shard '%s'", name)
+		}
+		seen[name] = true
+		for i := 0; i < virtualNodes; i++ {
+			point := hashKey(name + "#" + strconv.Itoa(i))
+			if _, taken := ring.nodes[point]; taken {
+				// the node with the smaller name keeps a colliding point
+				continue
+			}
+			ring.nodes[point] = node
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring, nil
+}
+
+// lookup returns the node owning key.
+func (r *hashRing) lookup(key string) int {
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i]]
+}
+
+// hashKey hashes with SHA-256, which spreads similar keys like the points
+// of a node evenly.
+func hashKey(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
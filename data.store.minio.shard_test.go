@@ -0,0 +1,226 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreSharded(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	var fakes []*fakeS3
+	var endpoints []string
+	for i := 0; i < 3; i++ {
+		fake, endpoint := newFakeS3(t)
+		fake.copies.Store(true)
+		fakes = append(fakes, fake)
+		endpoints = append(endpoints, endpoint)
+	}
+	newSharded := func(endpoints ...string) store.DataStoreSharded {
+		var shards []comby.DataStore
+		for _, endpoint := range endpoints {
+			shards = append(shards, store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123"))
+		}
+		sharded, err := store.NewDataStoreSharded(shards, store.ShardOptionWithVirtualNodes(64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sharded.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return sharded
+	}
+	get := func(sharded store.DataStoreSharded, objectName string) string {
+		model, err := sharded.Get(ctx,
+			comby.DataStoreGetOptionWithBucketName("shard-bucket"),
+			comby.DataStoreGetOptionWithObjectName(objectName),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(model.Data)
+	}
+
+	// objects are spread over both shards
+	sharded := newSharded(endpoints[:2]...)
+	const numObjects = 60
+	for i := 0; i < numObjects; i++ {
+		if err = sharded.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("shard-bucket"),
+			comby.DataStoreSetOptionWithObjectName(fmt.Sprintf("object-%d", i)),
+			comby.DataStoreSetOptionWithData([]byte(fmt.Sprintf("data-%d", i))),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	onShard := func(fake *fakeS3) int {
+		n := 0
+		for i := 0; i < numObjects; i++ {
+			if fake.object("shard-bucket", fmt.Sprintf("object-%d", i)) != nil {
+				n++
+			}
+		}
+		return n
+	}
+	if a, b := onShard(fakes[0]), onShard(fakes[1]); a == 0 || b == 0 || a+b != numObjects {
+		t.Fatalf("wrong distribution: %d/%d", a, b)
+	}
+	if got := get(sharded, "object-7"); got != "data-7" {
+		t.Fatalf("wrong data: %s", got)
+	}
+
+	// aggregates cover all shards
+	if total := sharded.Total(ctx); total != numObjects {
+		t.Fatalf("wrong total: %d", total)
+	}
+	items, total, err := sharded.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != numObjects || len(items) != numObjects {
+		t.Fatalf("wrong list: %d/%d", total, len(items))
+	}
+	info, err := sharded.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumBuckets != 1 || info.NumObjects != numObjects {
+		t.Fatalf("wrong info: %+v", info)
+	}
+
+	// add a shard: objects stay readable until they are moved
+	sharded = newSharded(endpoints...)
+	plan, err := sharded.RebalanceDryRun(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Moves) == 0 || len(plan.Moves) == numObjects {
+		t.Fatalf("wrong number of moves: %d", len(plan.Moves))
+	}
+	for _, move := range plan.Moves {
+		if move.To != "minio://"+endpoints[2] {
+			t.Fatalf("unexpected move: %+v", move)
+		}
+	}
+	if len(plan.Moves) < 3 {
+		t.Fatalf("expected at least 3 moves, got %d", len(plan.Moves))
+	}
+	if got := get(sharded, plan.Moves[0].ObjectName); got == "" {
+		t.Fatal("object not readable before rebalancing")
+	}
+
+	// the shard order does not change the owners
+	reversed := newSharded(endpoints[2], endpoints[1], endpoints[0])
+	for i := 0; i < numObjects; i++ {
+		objectName := fmt.Sprintf("object-%d", i)
+		if sharded.Shard("shard-bucket", objectName).String() != reversed.Shard("shard-bucket", objectName).String() {
+			t.Fatalf("owner of %s depends on the shard order", objectName)
+		}
+	}
+
+	// objects can be copied before they are moved
+	notMoved := plan.Moves[2].ObjectName
+	if err = sharded.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("shard-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName(notMoved),
+		comby.DataStoreCopyOptionWithDstBucketName("shard-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("copy-not-moved"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(sharded, "copy-not-moved"), get(sharded, notMoved); got != want {
+		t.Fatalf("wrong data: %s", got)
+	}
+	if err = sharded.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("shard-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("copy-not-moved"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// writes and deletes before rebalancing are not undone by it
+	updated, deleted := plan.Moves[0].ObjectName, plan.Moves[1].ObjectName
+	if err = sharded.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("shard-bucket"),
+		comby.DataStoreSetOptionWithObjectName(updated),
+		comby.DataStoreSetOptionWithData([]byte("updated")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = sharded.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("shard-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName(deleted),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sharded.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("shard-bucket"),
+		comby.DataStoreGetOptionWithObjectName(deleted),
+	); err == nil {
+		t.Fatal("deleted object still readable")
+	}
+
+	result, err := sharded.Rebalance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Errors) != 0 || len(result.Moves) != len(plan.Moves)-1 || result.Checked != numObjects {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	stale := 0
+	for _, move := range result.Moves {
+		if move.Stale {
+			stale++
+			if move.ObjectName != updated {
+				t.Fatalf("unexpected stale move: %+v", move)
+			}
+		}
+	}
+	if stale != 1 {
+		t.Fatalf("expected 1 stale move, got %d", stale)
+	}
+	if got := get(sharded, updated); got != "updated" {
+		t.Fatalf("newer object overwritten: %s", got)
+	}
+	if n := onShard(fakes[2]); n != len(plan.Moves)-1 {
+		t.Fatalf("expected %d objects on the new shard, got %d", len(plan.Moves)-1, n)
+	}
+	if sum := onShard(fakes[0]) + onShard(fakes[1]) + onShard(fakes[2]); sum != numObjects-1 {
+		t.Fatalf("expected %d objects, got %d", numObjects-1, sum)
+	}
+	if got := get(sharded, plan.Moves[2%len(plan.Moves)].ObjectName); got == "" {
+		t.Fatal("object not readable after rebalancing")
+	}
+
+	// copies between shards
+	srcObjectName, srcData := "object-1", "data-1"
+	for i := 2; srcObjectName == updated || srcObjectName == deleted; i++ {
+		srcObjectName, srcData = fmt.Sprintf("object-%d", i), fmt.Sprintf("data-%d", i)
+	}
+	dstObjectName := "copy-0"
+	for i := 1; sharded.Shard("shard-bucket", dstObjectName) == sharded.Shard("shard-bucket", srcObjectName); i++ {
+		dstObjectName = fmt.Sprintf("copy-%d", i)
+	}
+	if err = sharded.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("shard-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName(srcObjectName),
+		comby.DataStoreCopyOptionWithDstBucketName("shard-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName(dstObjectName),
+	); err != nil {
+		t.Fatal(err)
+	}
+	model, err := sharded.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("shard-bucket"),
+		comby.DataStoreGetOptionWithObjectName(dstObjectName),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(model.Data) != srcData {
+		t.Fatalf("wrong data: %s", model.Data)
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectStat holds the metadata of an object without its data.
+type ObjectStat struct {
+	BucketName   string
+	ObjectName   string
+	VersionID    string
+	ETag         string
+	ContentType  string
+	Size         int64
+	LastModified time.Time
+	UserMetadata map[string]string
+	// Retention is nil if the object version is not under retention.
+	Retention *ObjectRetention
+	LegalHold bool
+}
+
+// Stat returns the metadata of an object including its lock state.
+func (dsm *dataStoreMinio) Stat(ctx context.Context, opts ...comby.DataStoreGetOption) (*ObjectStat, error) {
+	getOpts := comby.DataStoreGetOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&getOpts); err != nil {
+			return nil, err
+		}
+	}
+	objectInfo, err := dsm.minioClient.StatObject(ctx, getOpts.BucketName, getOpts.ObjectName, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("StatObject(%s/%s): %w", getOpts.BucketName, getOpts.ObjectName, err)
+	}
+	return newObjectStat(getOpts.BucketName, objectInfo), nil
+}
+
+func newObjectStat(bucketName string, objectInfo minio.ObjectInfo) *ObjectStat {
+	stat := &ObjectStat{
+		BucketName:   bucketName,
+		ObjectName:   objectInfo.Key,
+		VersionID:    objectInfo.VersionID,
+		ETag:         objectInfo.ETag,
+		ContentType:  objectInfo.ContentType,
+		Size:         objectInfo.Size,
+		LastModified: objectInfo.LastModified,
+		UserMetadata: objectInfo.UserMetadata,
+		LegalHold:    objectInfo.Metadata.Get("X-Amz-Object-Lock-Legal-Hold") == string(minio.LegalHoldEnabled),
+	}
+	if mode := objectInfo.Metadata.Get("X-Amz-Object-Lock-Mode"); mode != "" {
+		retainUntil, _ := time.Parse(time.RFC3339, objectInfo.Metadata.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+		stat.Retention = &ObjectRetention{
+			Mode:        mode,
+			RetainUntil: retainUntil,
+		}
+	}
+	return stat
+}
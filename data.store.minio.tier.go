@@ -0,0 +1,639 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// User metadata of stubs left in the hot tier.
+const (
+	tierMetaLocation   = "Tier-Location"
+	tierMetaSize       = "Tier-Size"
+	tierMetaArchivedAt = "Tier-Archived-At"
+)
+
+// tierCold is the location of archived objects.
+const tierCold = "cold"
+
+// Stubs hold tierStubPrefix followed by a random hex id, so that every stub
+// has its own ETag and a conditional write on a stub matches no other object.
+const (
+	tierStubPrefix = "comby-tier-stub:"
+	tierStubSize   = int64(len(tierStubPrefix) + 32)
+)
+
+// defaultTierOrphanGrace is the minimum age of cold objects removed as
+// orphans unless configured with TierOptionWithOrphanGrace.
+const defaultTierOrphanGrace = time.Hour
+
+// TierOptions configure a tiered store. Objects are archived if they are
+// older than MaxAge and were not read for MaxIdle, if set.
+type TierOptions struct {
+	// MaxAge archives objects not modified for this long.
+	MaxAge time.Duration
+	// MaxIdle archives objects not read for this long. Reads are tracked in
+	// memory, objects not read since the store was initialized count as
+	// idle since their last modification.
+	MaxIdle time.Duration
+	// Interval runs Tier periodically if set.
+	Interval time.Duration
+	// RecallOnGet moves archived objects back to the hot tier when read.
+	RecallOnGet bool
+	// OrphanGrace is the minimum age of cold objects without stub before
+	// Tier removes them. A younger one may be the copy of an archive still
+	// in progress, e.g. by another replica, whose stub is not written yet.
+	OrphanGrace time.Duration
+}
+
+type TierOption func(opt *TierOptions) (*TierOptions, error)
+
+// TierOptionWithMaxAge archives objects not modified for maxAge.
+func TierOptionWithMaxAge(maxAge time.Duration) TierOption {
+	return func(opt *TierOptions) (*TierOptions, error) {
+		opt.MaxAge = maxAge
+		return opt, nil
+	}
+}
+
+// TierOptionWithMaxIdle archives objects not read for maxIdle.
+func TierOptionWithMaxIdle(maxIdle time.Duration) TierOption {
+	return func(opt *TierOptions) (*TierOptions, error) {
+		opt.MaxIdle = maxIdle
+		return opt, nil
+	}
+}
+
+// TierOptionWithInterval runs Tier periodically.
+func TierOptionWithInterval(interval time.Duration) TierOption {
+	return func(opt *TierOptions) (*TierOptions, error) {
+		opt.Interval = interval
+		return opt, nil
+	}
+}
+
+// TierOptionWithRecallOnGet moves archived objects back to the hot tier when
+// read, otherwise they are read from the cold tier every time.
+func TierOptionWithRecallOnGet(recall bool) TierOption {
+	return func(opt *TierOptions) (*TierOptions, error) {
+		opt.RecallOnGet = recall
+		return opt, nil
+	}
+}
+
+// TierOptionWithOrphanGrace sets the minimum age of cold objects without
+// stub before they are removed, one hour by default. It must exceed the time
+// needed to archive an object.
+func TierOptionWithOrphanGrace(grace time.Duration) TierOption {
+	return func(opt *TierOptions) (*TierOptions, error) {
+		if grace <= 0 {
+			return nil, fmt.Errorf("invalid orphan grace period %s", grace)
+		}
+		opt.OrphanGrace = grace
+		return opt, nil
+	}
+}
+
+// TierError reports an object that could not be archived.
+type TierError struct {
+	BucketName string
+	ObjectName string
+	Err        error
+}
+
+func (e *TierError) Error() string {
+	return fmt.Sprintf("failed to archive object %s/%s: %v", e.BucketName, e.ObjectName, e.Err)
+}
+
+func (e *TierError) Unwrap() error {
+	return e.Err
+}
+
+// TierResult summarizes a tiering run.
+type TierResult struct {
+	// Checked is the number of objects in the hot tier.
+	Checked int
+	// Archived is the number of objects moved to the cold tier.
+	Archived int
+	// Orphaned is the number of cold objects removed because the hot tier
+	// no longer points to them.
+	Orphaned int
+	// Errors holds one entry per object that could not be archived.
+	Errors []*TierError
+}
+
+// TierInfo reports the distribution of objects over the tiers.
+type TierInfo struct {
+	HotObjects  int64
+	HotBytes    int64
+	ColdObjects int64
+	ColdBytes   int64
+}
+
+// DataStoreTiered is a comby.DataStore keeping recent objects in a hot MinIO
+// store and archiving older ones to a cold S3-compatible store. Archived
+// objects leave a small stub in the hot tier and are read transparently.
+type DataStoreTiered interface {
+	comby.DataStore
+
+	// Tier archives the objects due for the cold tier.
+	Tier(ctx context.Context) (*TierResult, error)
+	// Recall moves an archived object back to the hot tier.
+	Recall(ctx context.Context, bucketName, objectName string) error
+	// TierInfo counts objects and bytes per tier.
+	TierInfo(ctx context.Context) (*TierInfo, error)
+}
+
+type dataStoreTiered struct {
+	hot     *dataStoreMinio
+	cold    *dataStoreMinio
+	options TierOptions
+
+	// last reads, by cache key
+	accessMu sync.Mutex
+	access   map[string]time.Time
+
+	// runs of Tier, one at a time
+	tierMu sync.Mutex
+
+	// periodic tiering
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	dataStoreInfoModel *comby.DataStoreInfoModel
+}
+
+// Make sure it implements interfaces
+var _ comby.DataStore = (*dataStoreTiered)(nil)
+var _ DataStoreTiered = (*dataStoreTiered)(nil)
+
+// NewDataStoreTiered returns a store archiving objects of hot to cold. Both
+// must have been created with NewDataStoreMinio and are initialized by Init
+// of the tiered store.
+func NewDataStoreTiered(hot, cold comby.DataStore, opts ...TierOption) (DataStoreTiered, error) {
+	hotStore, ok := hot.(*dataStoreMinio)
+	if !ok {
+		return nil, fmt.Errorf("hot store '%s' is not a minio data store", hot.String())
+	}
+	coldStore, ok := cold.(*dataStoreMinio)
+	if !ok {
+		return nil, fmt.Errorf("cold store '%s' is not a minio data store", cold.String())
+	}
+	dsti := &dataStoreTiered{
+		hot:     hotStore,
+		cold:    coldStore,
+		options: TierOptions{RecallOnGet: true, OrphanGrace: defaultTierOrphanGrace},
+		access:  make(map[string]time.Time),
+		dataStoreInfoModel: &comby.DataStoreInfoModel{
+			StoreType: "minio-tiered",
+		},
+	}
+	for _, opt := range opts {
+		if _, err := opt(&dsti.options); err != nil {
+			return nil, err
+		}
+	}
+	if dsti.options.MaxAge <= 0 && dsti.options.MaxIdle <= 0 {
+		return nil, errors.New("tiering needs a maximum age or idle time")
+	}
+	return dsti, nil
+}
+
+func (dsti *dataStoreTiered) Init(ctx context.Context, opts ...comby.DataStoreOption) error {
+	if err := dsti.hot.Init(ctx, opts...); err != nil {
+		return err
+	}
+	if err := dsti.cold.Init(ctx, opts...); err != nil {
+		return err
+	}
+	dsti.stopTiering()
+	if dsti.options.Interval > 0 {
+		tierCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		dsti.cancel = cancel
+		dsti.wg.Add(1)
+		go dsti.tierPeriodically(tierCtx)
+	}
+	return nil
+}
+
+// Get reads archived objects from the cold tier, recalling them if enabled.
+func (dsti *dataStoreTiered) Get(ctx context.Context, opts ...comby.DataStoreGetOption) (*comby.DataModel, error) {
+	getOpts := comby.DataStoreGetOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&getOpts); err != nil {
+			return nil, err
+		}
+	}
+	model, err := dsti.hot.Get(ctx, opts...)
+	if err != nil {
+		return model, err
+	}
+	dsti.touch(getOpts.BucketName, getOpts.ObjectName)
+
+	if !isStubData(model.Data) {
+		return model, nil
+	}
+	stub, err := dsti.stub(ctx, getOpts.BucketName, getOpts.ObjectName)
+	if err != nil || stub == nil {
+		return model, err
+	}
+	if dsti.options.RecallOnGet {
+		if err := dsti.recall(ctx, getOpts.BucketName, getOpts.ObjectName, stub); err != nil {
+			return nil, err
+		}
+		return dsti.hot.Get(ctx, opts...)
+	}
+	return dsti.cold.Get(ctx, opts...)
+}
+
+func (dsti *dataStoreTiered) Set(ctx context.Context, opts ...comby.DataStoreSetOption) error {
+	setOpts := comby.DataStoreSetOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&setOpts); err != nil {
+			return err
+		}
+	}
+	// a replaced stub orphans its cold object, Tier removes it
+	if err := dsti.hot.Set(ctx, opts...); err != nil {
+		return err
+	}
+	dsti.touch(setOpts.BucketName, setOpts.ObjectName)
+	return nil
+}
+
+// Copy copies archived sources from the cold tier into the hot tier.
+func (dsti *dataStoreTiered) Copy(ctx context.Context, opts ...comby.DataStoreCopyOption) error {
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return err
+		}
+	}
+	stub, err := dsti.stub(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName)
+	if err != nil {
+		return err
+	}
+	if stub != nil {
+		return CopyAcross(ctx, dsti.cold, dsti.hot, opts...)
+	}
+	return dsti.hot.Copy(ctx, opts...)
+}
+
+func (dsti *dataStoreTiered) List(ctx context.Context, opts ...comby.DataStoreListOption) ([]*comby.DataModel, int64, error) {
+	return dsti.hot.List(ctx, opts...)
+}
+
+// Delete removes an object from both tiers.
+func (dsti *dataStoreTiered) Delete(ctx context.Context, opts ...comby.DataStoreDeleteOption) error {
+	deleteOpts := comby.DataStoreDeleteOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&deleteOpts); err != nil {
+			return err
+		}
+	}
+	stub, err := dsti.stub(ctx, deleteOpts.BucketName, deleteOpts.ObjectName)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if err := dsti.hot.Delete(ctx, opts...); err != nil {
+		return err
+	}
+	dsti.forget(deleteOpts.BucketName, deleteOpts.ObjectName)
+	if stub != nil {
+		return dsti.cold.Delete(ctx, opts...)
+	}
+	return nil
+}
+
+func (dsti *dataStoreTiered) Total(ctx context.Context) int64 {
+	return dsti.hot.Total(ctx)
+}
+
+func (dsti *dataStoreTiered) Close(ctx context.Context) error {
+	dsti.stopTiering()
+	return errors.Join(dsti.hot.Close(ctx), dsti.cold.Close(ctx))
+}
+
+func (dsti *dataStoreTiered) Options() comby.DataStoreOptions {
+	return dsti.hot.Options()
+}
+
+func (dsti *dataStoreTiered) String() string {
+	return "tiered://" + dsti.hot.Endpoint + "+" + dsti.cold.Endpoint
+}
+
+// Info reports the objects of the hot tier with the sizes of archived
+// objects. The distribution over the tiers is reported by TierInfo.
+func (dsti *dataStoreTiered) Info(ctx context.Context) (*comby.DataStoreInfoModel, error) {
+	hotInfo, err := dsti.hot.Info(ctx)
+	if err != nil {
+		return dsti.dataStoreInfoModel, err
+	}
+	tierInfo, err := dsti.TierInfo(ctx)
+	if err != nil {
+		return dsti.dataStoreInfoModel, err
+	}
+	dsti.dataStoreInfoModel.LastUpdateTime = hotInfo.LastUpdateTime
+	dsti.dataStoreInfoModel.NumBuckets = hotInfo.NumBuckets
+	dsti.dataStoreInfoModel.NumObjects = hotInfo.NumObjects
+	dsti.dataStoreInfoModel.TotalSizeInBytes = tierInfo.HotBytes + tierInfo.ColdBytes
+	dsti.dataStoreInfoModel.ConnectionInfo = dsti.hot.dataStoreInfoModel.ConnectionInfo + "; " + dsti.cold.dataStoreInfoModel.ConnectionInfo
+	return dsti.dataStoreInfoModel, nil
+}
+
+// Reset resets both tiers.
+func (dsti *dataStoreTiered) Reset(ctx context.Context) error {
+	dsti.accessMu.Lock()
+	dsti.access = make(map[string]time.Time)
+	dsti.accessMu.Unlock()
+	return errors.Join(dsti.hot.Reset(ctx), dsti.cold.Reset(ctx))
+}
+
+// TierInfo counts the objects of the hot tier, not counting stubs, and the
+// objects of the cold tier.
+func (dsti *dataStoreTiered) TierInfo(ctx context.Context) (*TierInfo, error) {
+	info := &TierInfo{}
+	err := dsti.walk(ctx, dsti.hot, func(bucketName string, object minio.ObjectInfo) error {
+		if object.Size == tierStubSize {
+			stub, err := dsti.stub(ctx, bucketName, object.Key)
+			if err != nil || stub != nil {
+				return err
+			}
+		}
+		info.HotObjects++
+		info.HotBytes += object.Size
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+	err = dsti.walk(ctx, dsti.cold, func(bucketName string, object minio.ObjectInfo) error {
+		info.ColdObjects++
+		info.ColdBytes += object.Size
+		return nil
+	})
+	return info, err
+}
+
+// Tier archives the objects due for the cold tier and removes cold objects
+// no stub points to anymore, once they are older than the orphan grace
+// period. Runs of the same store do not overlap.
+func (dsti *dataStoreTiered) Tier(ctx context.Context) (*TierResult, error) {
+	dsti.tierMu.Lock()
+	defer dsti.tierMu.Unlock()
+	result := &TierResult{}
+	now := time.Now()
+	err := dsti.walk(ctx, dsti.hot, func(bucketName string, object minio.ObjectInfo) error {
+		result.Checked++
+		// empty objects and stubs stay
+		if object.Size == 0 {
+			return nil
+		}
+		if object.Size == tierStubSize {
+			stub, err := dsti.stub(ctx, bucketName, object.Key)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if stub != nil {
+				return nil
+			}
+		}
+		if dsti.options.MaxAge > 0 && now.Sub(object.LastModified) < dsti.options.MaxAge {
+			return nil
+		}
+		if dsti.options.MaxIdle > 0 && now.Sub(dsti.lastAccess(bucketName, object.Key, object.LastModified)) < dsti.options.MaxIdle {
+			return nil
+		}
+		if err := dsti.archive(ctx, bucketName, object); err != nil {
+			result.Errors = append(result.Errors, &TierError{BucketName: bucketName, ObjectName: object.Key, Err: err})
+			return nil
+		}
+		result.Archived++
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = dsti.walk(ctx, dsti.cold, func(bucketName string, object minio.ObjectInfo) error {
+		if time.Since(object.LastModified) < dsti.options.OrphanGrace {
+			return nil
+		}
+		stub, err := dsti.stub(ctx, bucketName, object.Key)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		if stub != nil {
+			return nil
+		}
+		if err := dsti.cold.Delete(ctx,
+			comby.DataStoreDeleteOptionWithBucketName(bucketName),
+			comby.DataStoreDeleteOptionWithObjectName(object.Key),
+		); err != nil {
+			return err
+		}
+		result.Orphaned++
+		return nil
+	})
+	dsti.hot.logger().Info("objects tiered", "store", dsti.String(), "checked", result.Checked,
+		"archived", result.Archived, "orphaned", result.Orphaned, "failed", len(result.Errors))
+	return result, err
+}
+
+func (dsti *dataStoreTiered) Recall(ctx context.Context, bucketName, objectName string) error {
+	stub, err := dsti.stub(ctx, bucketName, objectName)
+	if err != nil || stub == nil {
+		return err
+	}
+	return dsti.recall(ctx, bucketName, objectName, stub)
+}
+
+// archive copies an object to the cold tier and replaces it with a stub,
+// unless it was changed in the meantime.
+func (dsti *dataStoreTiered) archive(ctx context.Context, bucketName string, object minio.ObjectInfo) error {
+	if err := CopyAcross(ctx, dsti.hot, dsti.cold,
+		comby.DataStoreCopyOptionWithSrcBucketName(bucketName),
+		comby.DataStoreCopyOptionWithSrcObjectName(object.Key),
+		comby.DataStoreCopyOptionWithDstBucketName(bucketName),
+		comby.DataStoreCopyOptionWithDstObjectName(object.Key),
+		DataStoreCopyOptionWithMatchETag(object.ETag),
+	); err != nil {
+		return err
+	}
+	objectInfo, err := dsti.hot.minioClient.StatObject(ctx, bucketName, object.Key, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("StatObject(%s/%s): %w", bucketName, object.Key, err)
+	}
+	opts := minio.PutObjectOptions{
+		ContentType: objectInfo.ContentType,
+		UserMetadata: map[string]string{
+			tierMetaLocation:   tierCold,
+			tierMetaSize:       strconv.FormatInt(object.Size, 10),
+			tierMetaArchivedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	stubData, err := newStubData()
+	if err != nil {
+		return err
+	}
+	header := http.Header{"If-Match": {quoteETag(object.ETag)}}
+	dsti.hot.invalidateCache(bucketName, object.Key)
+	_, err = dsti.hot.minioClient.PutObject(withRequestHeader(ctx, header), bucketName, object.Key, bytes.NewReader(stubData), tierStubSize, opts)
+	if err != nil {
+		// the object changed while it was copied, the cold copy is an orphan
+		// unless another run archived the object meanwhile
+		if isConditionalWriteFailed(err) {
+			err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		err = fmt.Errorf("PutObject(%s/%s): %w", bucketName, object.Key, err)
+		if stub, stubErr := dsti.stub(ctx, bucketName, object.Key); stubErr != nil || stub != nil {
+			return err
+		}
+		return errors.Join(err, dsti.cold.Delete(ctx,
+			comby.DataStoreDeleteOptionWithBucketName(bucketName),
+			comby.DataStoreDeleteOptionWithObjectName(object.Key),
+		))
+	}
+	return nil
+}
+
+// recall writes an archived object back over its stub and removes it from
+// the cold tier. A stub replaced in the meantime is left alone, the ETag of
+// a stub is unique to it.
+func (dsti *dataStoreTiered) recall(ctx context.Context, bucketName, objectName string, stub *minio.ObjectInfo) error {
+	model, err := dsti.cold.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName(bucketName),
+		comby.DataStoreGetOptionWithObjectName(objectName),
+	)
+	if err != nil {
+		return fmt.Errorf("'%s' failed to recall %s/%s: %w", dsti.cold.String(), bucketName, objectName, err)
+	}
+	_, err = dsti.hot.SetWithResult(ctx,
+		comby.DataStoreSetOptionWithBucketName(bucketName),
+		comby.DataStoreSetOptionWithObjectName(objectName),
+		comby.DataStoreSetOptionWithContentType(stub.ContentType),
+		comby.DataStoreSetOptionWithData(model.Data),
+		DataStoreSetOptionWithMatchETag(stub.ETag),
+	)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return dsti.cold.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName(bucketName),
+		comby.DataStoreDeleteOptionWithObjectName(objectName),
+	)
+}
+
+// stub returns the stub of an archived object, nil if the object is in the
+// hot tier.
+func (dsti *dataStoreTiered) stub(ctx context.Context, bucketName, objectName string) (*minio.ObjectInfo, error) {
+	objectInfo, err := dsti.hot.minioClient.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if objectInfo.UserMetadata[tierMetaLocation] != tierCold {
+		return nil, nil
+	}
+	return &objectInfo, nil
+}
+
+// newStubData returns the content of a new stub.
+func newStubData() ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return []byte(tierStubPrefix + hex.EncodeToString(id)), nil
+}
+
+// isStubData reports whether data may be the content of a stub. Only the
+// metadata returned by stub tells for sure.
+func isStubData(data []byte) bool {
+	return int64(len(data)) == tierStubSize && bytes.HasPrefix(data, []byte(tierStubPrefix))
+}
+
+// walk calls fn for all objects of a store.
+func (dsti *dataStoreTiered) walk(ctx context.Context, store *dataStoreMinio, fn func(bucketName string, object minio.ObjectInfo) error) error {
+	buckets, err := store.minioClient.ListBuckets(ctx)
+	if err != nil {
+		return fmt.Errorf("'%s': %w", store.String(), err)
+	}
+	for _, bucket := range buckets {
+		objectCh := store.minioClient.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{
+			Recursive: true,
+		})
+		for object := range objectCh {
+			if object.Err != nil {
+				return fmt.Errorf("failed to list objects in bucket %s of '%s': %w", bucket.Name, store.String(), object.Err)
+			}
+			if err := fn(bucket.Name, object); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (dsti *dataStoreTiered) touch(bucketName, objectName string) {
+	dsti.accessMu.Lock()
+	defer dsti.accessMu.Unlock()
+	dsti.access[cacheKey(bucketName, objectName)] = time.Now()
+}
+
+func (dsti *dataStoreTiered) forget(bucketName, objectName string) {
+	dsti.accessMu.Lock()
+	defer dsti.accessMu.Unlock()
+	delete(dsti.access, cacheKey(bucketName, objectName))
+}
+
+// lastAccess returns the last read or write of an object, lastModified if
+// none was seen.
+func (dsti *dataStoreTiered) lastAccess(bucketName, objectName string, lastModified time.Time) time.Time {
+	dsti.accessMu.Lock()
+	defer dsti.accessMu.Unlock()
+	if accessed, ok := dsti.access[cacheKey(bucketName, objectName)]; ok && accessed.After(lastModified) {
+		return accessed
+	}
+	return lastModified
+}
+
+func (dsti *dataStoreTiered) tierPeriodically(ctx context.Context) {
+	defer dsti.wg.Done()
+	ticker := time.NewTicker(dsti.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := dsti.Tier(ctx); err != nil {
+			dsti.hot.logger().Warn("tiering failed", "store", dsti.String(), "error", err)
+		}
+	}
+}
+
+func (dsti *dataStoreTiered) stopTiering() {
+	if dsti.cancel != nil {
+		dsti.cancel()
+		dsti.wg.Wait()
+		dsti.cancel = nil
+	}
+}
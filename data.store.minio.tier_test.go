@@ -0,0 +1,163 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreTiered(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	hotFake, hotEndpoint := newFakeS3(t)
+	coldFake, coldEndpoint := newFakeS3(t)
+
+	newTiered := func(opts ...store.TierOption) store.DataStoreTiered {
+		tiered, err := store.NewDataStoreTiered(
+			store.NewDataStoreMinio(hotEndpoint, false, "ROOTNAME", "CHANGEME123"),
+			store.NewDataStoreMinio(coldEndpoint, false, "ROOTNAME", "CHANGEME123"),
+			opts...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tiered.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return tiered
+	}
+	set := func(tiered store.DataStoreTiered, objectName, data string) {
+		if err := tiered.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("tier-bucket"),
+			comby.DataStoreSetOptionWithObjectName(objectName),
+			comby.DataStoreSetOptionWithContentType("text/plain"),
+			comby.DataStoreSetOptionWithData([]byte(data)),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(tiered store.DataStoreTiered, objectName string) string {
+		model, err := tiered.Get(ctx,
+			comby.DataStoreGetOptionWithBucketName("tier-bucket"),
+			comby.DataStoreGetOptionWithObjectName(objectName),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(model.Data)
+	}
+
+	// old objects are archived and leave a stub
+	tiered := newTiered(store.TierOptionWithMaxAge(time.Millisecond))
+	set(tiered, "a.txt", "alpha")
+	set(tiered, "b.txt", "beta")
+	time.Sleep(5 * time.Millisecond)
+	result, err := tiered.Tier(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 2 || result.Archived != 2 || len(result.Errors) != 0 {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	// every stub has its own content and thus its own ETag
+	stubA, stubB := hotFake.object("tier-bucket", "a.txt"), hotFake.object("tier-bucket", "b.txt")
+	if !strings.HasPrefix(string(stubA), "comby-tier-stub:") || !strings.HasPrefix(string(stubB), "comby-tier-stub:") {
+		t.Fatalf("expected stubs, got %q and %q", stubA, stubB)
+	}
+	if string(stubA) == string(stubB) {
+		t.Fatalf("expected unique stubs, got %q twice", stubA)
+	}
+	if data := coldFake.object("tier-bucket", "a.txt"); string(data) != "alpha" {
+		t.Fatalf("wrong cold data: %q", data)
+	}
+	info, err := tiered.TierInfo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HotObjects != 0 || info.ColdObjects != 2 || info.ColdBytes != 9 {
+		t.Fatalf("wrong tier info: %+v", info)
+	}
+	storeInfo, err := tiered.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storeInfo.NumObjects != 2 || storeInfo.TotalSizeInBytes != 9 {
+		t.Fatalf("wrong info: %+v", storeInfo)
+	}
+	if strings.Contains(storeInfo.ConnectionInfo, "objects") {
+		t.Fatalf("expected plain connection info, got %q", storeInfo.ConnectionInfo)
+	}
+
+	// reads recall archived objects
+	if got := get(tiered, "a.txt"); got != "alpha" {
+		t.Fatalf("wrong data: %q", got)
+	}
+	if data := hotFake.object("tier-bucket", "a.txt"); string(data) != "alpha" {
+		t.Fatalf("expected recalled object, got %q", data)
+	}
+	if coldFake.object("tier-bucket", "a.txt") != nil {
+		t.Fatal("expected recalled object to leave the cold tier")
+	}
+
+	// or read them from the cold tier
+	coldReader := newTiered(store.TierOptionWithMaxAge(time.Hour), store.TierOptionWithRecallOnGet(false),
+		store.TierOptionWithOrphanGrace(time.Millisecond))
+	if got := get(coldReader, "b.txt"); got != "beta" {
+		t.Fatalf("wrong data: %q", got)
+	}
+	if data := hotFake.object("tier-bucket", "b.txt"); string(data) != string(stubB) {
+		t.Fatalf("expected stub, got %q", data)
+	}
+
+	// overwritten stubs orphan their cold object, tiering removes it once it
+	// is older than the grace period
+	set(coldReader, "b.txt", "beta-2")
+	if result, err = newTiered(store.TierOptionWithMaxAge(time.Hour)).Tier(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if result.Orphaned != 0 || coldFake.object("tier-bucket", "b.txt") == nil {
+		t.Fatalf("expected young orphan to be kept: %+v", result)
+	}
+	result, err = coldReader.Tier(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Archived != 0 || result.Orphaned != 1 {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	if got := get(coldReader, "b.txt"); got != "beta-2" {
+		t.Fatalf("wrong data: %q", got)
+	}
+
+	// recently read objects stay hot
+	idle := newTiered(store.TierOptionWithMaxIdle(100 * time.Millisecond))
+	set(idle, "c.txt", "gamma")
+	if result, err = idle.Tier(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if result.Archived != 0 {
+		t.Fatalf("wrong result: %+v", result)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if result, err = idle.Tier(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if result.Archived != 3 {
+		t.Fatalf("wrong result: %+v", result)
+	}
+
+	// deletes remove both tiers
+	if err = idle.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("tier-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("c.txt"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if hotFake.object("tier-bucket", "c.txt") != nil || coldFake.object("tier-bucket", "c.txt") != nil {
+		t.Fatal("expected object to be deleted from both tiers")
+	}
+}
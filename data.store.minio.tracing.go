@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of the store.
+const tracerName = "github.com/gradientzero/comby-store-minio"
+
+// Span attributes set by the store.
+const (
+	spanAttrEndpoint  = "minio.endpoint"
+	spanAttrBucket    = "minio.bucket"
+	spanAttrObject    = "minio.object"
+	spanAttrSize      = "minio.size"
+	spanAttrObjects   = "minio.objects"
+	spanAttrEncrypted = "minio.encrypted"
+)
+
+// DataStoreOptionWithTracerProvider sets the tracer provider of the store.
+// The global provider of otel is used if none is given.
+func DataStoreOptionWithTracerProvider(tracerProvider trace.TracerProvider) comby.DataStoreOption {
+	return comby.DataStoreOptionWithAttribute(DATA_STORE_ATTRIBUTE_TRACER_PROVIDER, tracerProvider)
+}
+
+// tracerProvider returns the configured tracer provider or the global one.
+func (dsm *dataStoreMinio) tracerProvider() trace.TracerProvider {
+	if tracerProvider, ok := attributeValue[trace.TracerProvider](dsm.options.Attributes, DATA_STORE_ATTRIBUTE_TRACER_PROVIDER); ok && tracerProvider != nil {
+		return tracerProvider
+	}
+	return otel.GetTracerProvider()
+}
+
+// operation is a store operation in progress. It is traced as a span, e.g.
+// "minio.Get", and observed by the metrics of the store, if any.
+type operation struct {
+	dsm          *dataStoreMinio
+	name         string
+	span         trace.Span
+	start        time.Time
+	bytesRead    int
+	bytesWritten int
+}
+
+// startOperation starts the span of a store operation.
+func (dsm *dataStoreMinio) startOperation(ctx context.Context, name, bucketName, objectName string) (context.Context, *operation) {
+	attrs := []attribute.KeyValue{
+		attribute.String(spanAttrEndpoint, dsm.Endpoint),
+		attribute.Bool(spanAttrEncrypted, dsm.options.CryptoService != nil),
+	}
+	if bucketName != "" {
+		attrs = append(attrs, attribute.String(spanAttrBucket, bucketName))
+	}
+	if objectName != "" {
+		attrs = append(attrs, attribute.String(spanAttrObject, objectName))
+	}
+	ctx, span := dsm.tracerProvider().Tracer(tracerName).Start(ctx, "minio."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx, &operation{dsm: dsm, name: name, span: span, start: time.Now()}
+}
+
+// read records the size of the data read by the operation.
+func (op *operation) read(size int) {
+	op.span.SetAttributes(attribute.Int(spanAttrSize, size))
+	op.bytesRead = size
+}
+
+// written records the size of the data written by the operation.
+func (op *operation) written(size int) {
+	op.span.SetAttributes(attribute.Int(spanAttrSize, size))
+	op.bytesWritten = size
+}
+
+// end records err, if any, ends the span and observes the operation.
+func (op *operation) end(err error) {
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+	}
+	op.span.End()
+	op.dsm.metrics().observeOperation(op, err)
+}
+
+// tracingTransport creates a child span for every S3 request and propagates
+// the trace context in its headers.
+func (dsm *dataStoreMinio) tracingTransport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base,
+		otelhttp.WithTracerProvider(dsm.tracerProvider()),
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return "S3 " + req.Method
+		}),
+	)
+}
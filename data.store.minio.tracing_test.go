@@ -0,0 +1,81 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDataStoreTracing(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	_, endpoint := newFakeS3(t)
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithTracerProvider(tracerProvider),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// store operations and their S3 requests are traced
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("trace-bucket"),
+		comby.DataStoreSetOptionWithObjectName("a.txt"),
+		comby.DataStoreSetOptionWithData([]byte("alpha")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("trace-bucket"),
+		comby.DataStoreGetOptionWithObjectName("missing.txt"),
+	); err == nil {
+		t.Fatal("expected error")
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	children := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		children[span.Parent().SpanID().String()]++
+	}
+	for _, name := range []string{"minio.Init", "minio.Set", "minio.Get"} {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("missing span %s", name)
+		}
+	}
+	attributes := func(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+		values := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			values[kv.Key] = kv.Value
+		}
+		return values
+	}
+
+	set := spans["minio.Set"]
+	setAttributes := attributes(set)
+	if setAttributes["minio.bucket"].AsString() != "trace-bucket" || setAttributes["minio.object"].AsString() != "a.txt" ||
+		setAttributes["minio.size"].AsInt64() != 5 || setAttributes["minio.encrypted"].AsBool() {
+		t.Fatalf("wrong attributes: %v", set.Attributes())
+	}
+	if children[set.SpanContext().SpanID().String()] == 0 {
+		t.Fatal("expected S3 requests as child spans of Set")
+	}
+
+	get := spans["minio.Get"]
+	if get.Status().Code != codes.Error || len(get.Events()) == 0 {
+		t.Fatalf("expected recorded error, got %+v", get.Status())
+	}
+	if children[get.SpanContext().SpanID().String()] == 0 {
+		t.Fatal("expected S3 requests as child spans of Get")
+	}
+}
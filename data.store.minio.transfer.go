@@ -0,0 +1,183 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// CopyAcross copies an object from the src store into the dst store. Both
+// stores must have been created with NewDataStoreMinio, they may point to
+// different endpoints and use different CryptoServices. The object is read
+// from src, decrypted with the CryptoService of src (if any), re-encrypted
+// with the CryptoService of dst (if any) and written with the original
+// content type and user metadata, unless they are replaced like in Copy. The
+// source conditions of Copy apply as well. If both stores share the same
+// endpoint, region, access key and CryptoService the object is copied
+// server-side instead,
+// unless the destination may only be created (see
+// DataStoreCopyOptionWithCreateOnly).
+func CopyAcross(ctx context.Context, src, dst comby.DataStore, opts ...comby.DataStoreCopyOption) (err error) {
+	srcStore, ok := src.(*dataStoreMinio)
+	if !ok {
+		return fmt.Errorf("source '%s' is not a minio data store", src.String())
+	}
+	dstStore, ok := dst.(*dataStoreMinio)
+	if !ok {
+		return fmt.Errorf("destination '%s' is not a minio data store", dst.String())
+	}
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return err
+		}
+	}
+
+	// same backend and same key: nothing to re-encrypt, copy server-side
+	createOnly, _ := attributeValue[bool](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_CREATE_ONLY)
+	if !createOnly && srcStore.sharesBackendWith(dstStore) {
+		return dstStore.Copy(ctx, opts...)
+	}
+	record := dstStore.newAuditRecord(ctx, "CopyAcross", copyOpts.DstBucketName, copyOpts.DstObjectName)
+	record.SourceBucket, record.SourceKey = copyOpts.SrcBucketName, copyOpts.SrcObjectName
+	defer func() { dstStore.audit(ctx, record, err) }()
+
+	// ensure destination bucket exists
+	if err := dstStore.ensureBucket(ctx, copyOpts.DstBucketName, copyOpts.Attributes); err != nil {
+		return err
+	}
+
+	objectInfo, err := srcStore.minioClient.StatObject(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("StatObject(%s/%s): %w", copyOpts.SrcBucketName, copyOpts.SrcObjectName, err)
+	}
+	srcOpts := minio.CopySrcOptions{}
+	srcOpts.MatchETag, _ = attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_MATCH_ETAG)
+	srcOpts.NoMatchETag, _ = attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_NONE_MATCH_ETAG)
+	srcOpts.MatchModifiedSince, _ = attributeValue[time.Time](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_MODIFIED_SINCE)
+	srcOpts.MatchUnmodifiedSince, _ = attributeValue[time.Time](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_UNMODIFIED_SINCE)
+	if !sourceMatches(objectInfo, srcOpts) {
+		return fmt.Errorf("StatObject(%s/%s): %w", copyOpts.SrcBucketName, copyOpts.SrcObjectName, ErrPreconditionFailed)
+	}
+
+	// read exactly the object checked above
+	getOpts := minio.GetObjectOptions{}
+	if err := getOpts.SetMatchETag(objectInfo.ETag); err != nil {
+		return err
+	}
+	minioObject, err := srcStore.minioClient.GetObject(ctx, copyOpts.SrcBucketName, copyOpts.SrcObjectName, getOpts)
+	if err != nil {
+		return fmt.Errorf("GetObject(%s/%s): %w", copyOpts.SrcBucketName, copyOpts.SrcObjectName, err)
+	}
+	defer minioObject.Close()
+
+	putOpts := minio.PutObjectOptions{
+		ContentType:  objectInfo.ContentType,
+		UserMetadata: objectInfo.UserMetadata,
+	}
+	if directive, _ := attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_METADATA_DIRECTIVE); directive == METADATA_DIRECTIVE_REPLACE {
+		putOpts.UserMetadata, _ = attributeValue[map[string]string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_USER_METADATA)
+		if contentType, _ := attributeValue[string](copyOpts.Attributes, DATA_STORE_ATTRIBUTE_CONTENT_TYPE); contentType != "" {
+			putOpts.ContentType = contentType
+		}
+	}
+
+	// neither side encrypts: stream the object without buffering it
+	var reader io.Reader = minioObject
+	objectSize := objectInfo.Size
+
+	// otherwise the whole object is needed to decrypt and re-encrypt it
+	if srcStore.options.CryptoService != nil || dstStore.options.CryptoService != nil {
+		data, err := io.ReadAll(minioObject)
+		if err != nil {
+			if isPreconditionFailed(err) {
+				err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+			}
+			return fmt.Errorf("'%s' failed to read %s/%s: %w", srcStore.String(), copyOpts.SrcBucketName, copyOpts.SrcObjectName, err)
+		}
+		if srcStore.options.CryptoService != nil && len(data) > 0 {
+			if data, err = srcStore.decrypt(data); err != nil {
+				return fmt.Errorf("'%s' failed to decrypt data: %w", srcStore.String(), err)
+			}
+		}
+		if dstStore.options.CryptoService != nil {
+			if data, err = dstStore.encrypt(data); err != nil {
+				return fmt.Errorf("'%s' failed to encrypt data: %w", dstStore.String(), err)
+			}
+		}
+		reader = bytes.NewReader(data)
+		objectSize = int64(len(data))
+	}
+
+	putCtx := ctx
+	if createOnly {
+		putCtx = withRequestHeader(ctx, http.Header{"If-None-Match": []string{"*"}})
+	}
+	dstStore.invalidateCache(copyOpts.DstBucketName, copyOpts.DstObjectName)
+	info, err := dstStore.minioClient.PutObject(putCtx, copyOpts.DstBucketName, copyOpts.DstObjectName, reader, objectSize, putOpts)
+	if err != nil {
+		if createOnly && isConditionalWriteFailed(err) {
+			err = fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+		}
+		return fmt.Errorf("PutObject(%s/%s, size=%d): %w", copyOpts.DstBucketName, copyOpts.DstObjectName, objectSize, err)
+	}
+	record.Size, record.ETag, record.VersionID = info.Size, info.ETag, info.VersionID
+	return nil
+}
+
+// MoveAcross copies an object from the src store into the dst store like
+// CopyAcross and removes the source object once the copy succeeded.
+func MoveAcross(ctx context.Context, src, dst comby.DataStore, opts ...comby.DataStoreCopyOption) error {
+	if err := CopyAcross(ctx, src, dst, opts...); err != nil {
+		return err
+	}
+	copyOpts := comby.DataStoreCopyOptions{
+		Attributes: comby.NewAttributes(),
+	}
+	for _, opt := range opts {
+		if _, err := opt(&copyOpts); err != nil {
+			return err
+		}
+	}
+	if err := src.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName(copyOpts.SrcBucketName),
+		comby.DataStoreDeleteOptionWithObjectName(copyOpts.SrcObjectName),
+	); err != nil {
+		return fmt.Errorf("copied %s/%s but failed to remove source: %w", copyOpts.SrcBucketName, copyOpts.SrcObjectName, err)
+	}
+	return nil
+}
+
+// sharesBackendWith reports whether both stores talk to the same endpoint
+// in the same region with the same access key and encrypt with the same
+// CryptoService, so objects can be copied server-side.
+func (dsm *dataStoreMinio) sharesBackendWith(other *dataStoreMinio) bool {
+	if dsm.Endpoint != other.Endpoint || dsm.minioOptions.Secure != other.minioOptions.Secure ||
+		dsm.minioOptions.Region != other.minioOptions.Region || dsm.options.BucketRegion != other.options.BucketRegion {
+		return false
+	}
+	// the destination reads the source with its own credentials
+	credsA, errA := dsm.minioOptions.Creds.Get()
+	credsB, errB := other.minioOptions.Creds.Get()
+	if errA != nil || errB != nil || credsA.AccessKeyID != credsB.AccessKeyID {
+		return false
+	}
+	a, b := dsm.options.CryptoService, other.options.CryptoService
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	// comparing interfaces holding uncomparable values would panic
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
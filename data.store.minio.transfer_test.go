@@ -0,0 +1,146 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreCopyAcross(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// two stores on the same endpoint but with different keys
+	srcCryptoService, err := comby.NewCryptoService([]byte("01234567890123456789012345678901"))
+	if err != nil {
+		t.Fatalf("failed to create crypto service: %v", err)
+	}
+	dstCryptoService, err := comby.NewCryptoService([]byte("abcdefghijabcdefghijabcdefghijab"))
+	if err != nil {
+		t.Fatalf("failed to create crypto service: %v", err)
+	}
+	srcStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123", comby.DataStoreOptionWithCryptoService(srcCryptoService))
+	if err = srcStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dstStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123", comby.DataStoreOptionWithCryptoService(dstCryptoService))
+	if err = dstStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := srcStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sourceData := []byte("data to re-encrypt")
+	if err := srcStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("across-src"),
+		comby.DataStoreSetOptionWithObjectName("object"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData(sourceData),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// copy into the other store
+	if err := store.CopyAcross(ctx, srcStore, dstStore,
+		comby.DataStoreCopyOptionWithSrcBucketName("across-src"),
+		comby.DataStoreCopyOptionWithSrcObjectName("object"),
+		comby.DataStoreCopyOptionWithDstBucketName("across-dst"),
+		comby.DataStoreCopyOptionWithDstObjectName("object"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// destination is readable with the destination key
+	if dataModel, err := dstStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("across-dst"),
+		comby.DataStoreGetOptionWithObjectName("object"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if string(dataModel.Data) != string(sourceData) {
+			t.Fatalf("copied data mismatch: got %q, want %q", string(dataModel.Data), string(sourceData))
+		}
+	}
+
+	// destination is not readable with the source key
+	if _, err := srcStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("across-dst"),
+		comby.DataStoreGetOptionWithObjectName("object"),
+	); err == nil {
+		t.Fatal("expected decryption with source key to fail")
+	}
+
+	// move back removes the copy
+	if err := store.MoveAcross(ctx, dstStore, srcStore,
+		comby.DataStoreCopyOptionWithSrcBucketName("across-dst"),
+		comby.DataStoreCopyOptionWithSrcObjectName("object"),
+		comby.DataStoreCopyOptionWithDstBucketName("across-src"),
+		comby.DataStoreCopyOptionWithDstObjectName("moved"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if srcStore.Total(ctx) != 2 {
+		t.Fatalf("wrong total after move: %d", srcStore.Total(ctx))
+	}
+
+	// reset database
+	if err := srcStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connections
+	if err := srcStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+	if err := dstStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
+
+func TestDataStoreCopyAcrossCredentials(t *testing.T) {
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// the fake does not copy server-side, only streamed copies succeed
+	newStore := func(accessKeyId string) comby.DataStore {
+		dataStore := store.NewDataStoreMinio(endpoint, false, accessKeyId, "CHANGEME123")
+		if err := dataStore.Init(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return dataStore
+	}
+	srcStore, dstStore, sameStore := newStore("SOURCE"), newStore("DESTINATION"), newStore("SOURCE")
+	if err := srcStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("across-src"),
+		comby.DataStoreSetOptionWithObjectName("object"),
+		comby.DataStoreSetOptionWithData([]byte("data")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	copyAcross := func(dst comby.DataStore) error {
+		return store.CopyAcross(ctx, srcStore, dst,
+			comby.DataStoreCopyOptionWithSrcBucketName("across-src"),
+			comby.DataStoreCopyOptionWithSrcObjectName("object"),
+			comby.DataStoreCopyOptionWithDstBucketName("across-dst"),
+			comby.DataStoreCopyOptionWithDstObjectName("object"),
+		)
+	}
+
+	// other credentials on the same endpoint stream the object
+	if err := copyAcross(dstStore); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.object("across-dst", "object")); got != "data" {
+		t.Fatalf("wrong data: %q", got)
+	}
+
+	// the same credentials copy server-side
+	if err := copyAcross(sameStore); err == nil {
+		t.Fatal("expected server-side copy")
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectVersion describes one version of an object in a versioned bucket.
+type ObjectVersion struct {
+	BucketName     string
+	ObjectName     string
+	VersionID      string
+	ETag           string
+	Size           int64
+	LastModified   time.Time
+	IsLatest       bool
+	IsDeleteMarker bool
+}
+
+// ListVersions lists all versions including delete markers of the object
+// given by bucket and object name, newest first.
+func (dsm *dataStoreMinio) ListVersions(ctx context.Context, opts ...comby.DataStoreGetOption) ([]*ObjectVersion, error) {
+	getOpts := comby.DataStoreGetOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&getOpts); err != nil {
+			return nil, err
+		}
+	}
+	var versions []*ObjectVersion
+	objectCh := dsm.minioClient.ListObjects(ctx, getOpts.BucketName, minio.ListObjectsOptions{
+		Prefix:       getOpts.ObjectName,
+		Recursive:    true,
+		WithVersions: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			return versions, fmt.Errorf("failed to list versions of %s/%s: %w", getOpts.BucketName, getOpts.ObjectName, object.Err)
+		}
+		// the prefix also matches objects with longer names
+		if object.Key != getOpts.ObjectName {
+			continue
+		}
+		versions = append(versions, &ObjectVersion{
+			BucketName:     getOpts.BucketName,
+			ObjectName:     object.Key,
+			VersionID:      object.VersionID,
+			ETag:           object.ETag,
+			Size:           object.Size,
+			LastModified:   object.LastModified,
+			IsLatest:       object.IsLatest,
+			IsDeleteMarker: object.IsDeleteMarker,
+		})
+	}
+	return versions, nil
+}
+
+// GetVersion gets and decrypts the given version of an object.
+func (dsm *dataStoreMinio) GetVersion(ctx context.Context, versionID string, opts ...comby.DataStoreGetOption) (*comby.DataModel, error) {
+	getOpts := comby.DataStoreGetOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&getOpts); err != nil {
+			return nil, err
+		}
+	}
+	opts2 := minio.GetObjectOptions{
+		VersionID: versionID,
+	}
+	return dsm.getObject(ctx, getOpts.BucketName, getOpts.ObjectName, opts2)
+}
+
+// DeleteVersion permanently removes the given version of an object. Unlike
+// Delete, which only adds a delete marker in versioned buckets, the version
+// cannot be restored afterwards.
+func (dsm *dataStoreMinio) DeleteVersion(ctx context.Context, versionID string, opts ...comby.DataStoreDeleteOption) (err error) {
+	deleteOpts := comby.DataStoreDeleteOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&deleteOpts); err != nil {
+			return err
+		}
+	}
+	record := dsm.newAuditRecord(ctx, "DeleteVersion", deleteOpts.BucketName, deleteOpts.ObjectName)
+	record.VersionID = versionID
+	defer func() { dsm.audit(ctx, record, err) }()
+	opts2 := minio.RemoveObjectOptions{
+		VersionID: versionID,
+	}
+	dsm.invalidateCache(deleteOpts.BucketName, deleteOpts.ObjectName)
+	return lockError(dsm.minioClient.RemoveObject(ctx, deleteOpts.BucketName, deleteOpts.ObjectName, opts2))
+}
+
+// RestoreVersion copies the given version of an object onto the object
+// itself, so it becomes the current version. Newer versions are kept.
+func (dsm *dataStoreMinio) RestoreVersion(ctx context.Context, versionID string, opts ...comby.DataStoreGetOption) (err error) {
+	getOpts := comby.DataStoreGetOptions{}
+	for _, opt := range opts {
+		if _, err := opt(&getOpts); err != nil {
+			return err
+		}
+	}
+	record := dsm.newAuditRecord(ctx, "RestoreVersion", getOpts.BucketName, getOpts.ObjectName)
+	record.SourceBucket, record.SourceKey, record.SourceVersionID = getOpts.BucketName, getOpts.ObjectName, versionID
+	defer func() { dsm.audit(ctx, record, err) }()
+	srcOpts := minio.CopySrcOptions{
+		Bucket:    getOpts.BucketName,
+		Object:    getOpts.ObjectName,
+		VersionID: versionID,
+	}
+	dstOpts := minio.CopyDestOptions{
+		Bucket: getOpts.BucketName,
+		Object: getOpts.ObjectName,
+	}
+	info, err := dsm.copyObject(ctx, dstOpts, srcOpts)
+	record.Size, record.ETag, record.VersionID = info.Size, info.ETag, info.VersionID
+	return err
+}
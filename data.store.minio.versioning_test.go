@@ -0,0 +1,113 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreVersioning(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store with versioned buckets
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123",
+		store.DataStoreOptionWithVersioning(true),
+	)
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set two versions of the same object
+	for _, value := range []string{"version1", "version2"} {
+		if err := dataStore.Set(ctx,
+			comby.DataStoreSetOptionWithBucketName("versioned-bucket"),
+			comby.DataStoreSetOptionWithObjectName("document"),
+			comby.DataStoreSetOptionWithContentType("text/plain"),
+			comby.DataStoreSetOptionWithData([]byte(value)),
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// List versions
+	versions, err := dataStore.ListVersions(ctx,
+		comby.DataStoreGetOptionWithBucketName("versioned-bucket"),
+		comby.DataStoreGetOptionWithObjectName("document"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("wrong number of versions: %d", len(versions))
+	}
+	if !versions[0].IsLatest {
+		t.Fatal("first version should be the latest")
+	}
+	oldVersionID := versions[1].VersionID
+
+	// Get old version
+	if dataModel, err := dataStore.GetVersion(ctx, oldVersionID,
+		comby.DataStoreGetOptionWithBucketName("versioned-bucket"),
+		comby.DataStoreGetOptionWithObjectName("document"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if string(dataModel.Data) != "version1" {
+			t.Fatalf("wrong value of old version: %q", dataModel.Data)
+		}
+	}
+
+	// Restore old version
+	if err := dataStore.RestoreVersion(ctx, oldVersionID,
+		comby.DataStoreGetOptionWithBucketName("versioned-bucket"),
+		comby.DataStoreGetOptionWithObjectName("document"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if dataModel, err := dataStore.Get(ctx,
+		comby.DataStoreGetOptionWithBucketName("versioned-bucket"),
+		comby.DataStoreGetOptionWithObjectName("document"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if string(dataModel.Data) != "version1" {
+			t.Fatalf("wrong value after restore: %q", dataModel.Data)
+		}
+	}
+
+	// Delete old version
+	if err := dataStore.DeleteVersion(ctx, oldVersionID,
+		comby.DataStoreDeleteOptionWithBucketName("versioned-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("document"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := dataStore.ListVersions(ctx,
+		comby.DataStoreGetOptionWithBucketName("versioned-bucket"),
+		comby.DataStoreGetOptionWithObjectName("document"),
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		if len(versions) != 2 {
+			t.Fatalf("wrong number of versions after delete: %d", len(versions))
+		}
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}
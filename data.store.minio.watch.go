@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// Values for ObjectEvent.Type.
+const (
+	OBJECT_EVENT_CREATED = "created"
+	OBJECT_EVENT_REMOVED = "removed"
+)
+
+// ObjectEvent is a bucket notification about a created or removed object.
+type ObjectEvent struct {
+	// Type is OBJECT_EVENT_CREATED or OBJECT_EVENT_REMOVED.
+	Type string
+	// Name is the S3 event name, e.g. s3:ObjectCreated:Put.
+	Name       string
+	BucketName string
+	ObjectName string
+	VersionID  string
+	ETag       string
+	Size       int64
+	Time       time.Time
+	// Resumed is set for events found by listing the bucket after a
+	// reconnect, they may duplicate events already delivered.
+	Resumed bool
+}
+
+// WatchOptions configure Watch.
+type WatchOptions struct {
+	Prefix string
+	Suffix string
+	// Resume lists objects modified while the watcher was disconnected,
+	// including reconnects done by the client on its own, and reports them
+	// as created. Removals in that time are lost.
+	Resume bool
+	// RetryMin and RetryMax bound the delay between reconnects.
+	RetryMin time.Duration
+	RetryMax time.Duration
+}
+
+type WatchOption func(opt *WatchOptions) (*WatchOptions, error)
+
+// WatchOptionWithPrefix only watches objects whose name starts with prefix.
+func WatchOptionWithPrefix(prefix string) WatchOption {
+	return func(opt *WatchOptions) (*WatchOptions, error) {
+		opt.Prefix = prefix
+		return opt, nil
+	}
+}
+
+// WatchOptionWithSuffix only watches objects whose name ends with suffix.
+func WatchOptionWithSuffix(suffix string) WatchOption {
+	return func(opt *WatchOptions) (*WatchOptions, error) {
+		opt.Suffix = suffix
+		return opt, nil
+	}
+}
+
+// WatchOptionWithResume reports objects modified while disconnected.
+func WatchOptionWithResume(resume bool) WatchOption {
+	return func(opt *WatchOptions) (*WatchOptions, error) {
+		opt.Resume = resume
+		return opt, nil
+	}
+}
+
+// WatchOptionWithRetry sets the minimum and maximum delay between
+// reconnects, the delay doubles after each failed attempt.
+func WatchOptionWithRetry(retryMin, retryMax time.Duration) WatchOption {
+	return func(opt *WatchOptions) (*WatchOptions, error) {
+		if retryMin <= 0 || retryMax < retryMin {
+			return nil, fmt.Errorf("invalid watch retry delays %s and %s", retryMin, retryMax)
+		}
+		opt.RetryMin = retryMin
+		opt.RetryMax = retryMax
+		return opt, nil
+	}
+}
+
+// Watch listens for created and removed objects in the bucket and calls
+// handler for each of them, one at a time. Lost connections are
+// re-established until ctx is done, which is the only way Watch returns
+// besides invalid options. The handler should be idempotent because events
+// may be delivered more than once.
+func (dsm *dataStoreMinio) Watch(ctx context.Context, bucketName string, handler func(ObjectEvent), opts ...WatchOption) error {
+	watchOpts := WatchOptions{
+		RetryMin: time.Second,
+		RetryMax: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		if _, err := opt(&watchOpts); err != nil {
+			return err
+		}
+	}
+	events := []string{
+		string(notification.ObjectCreatedAll),
+		string(notification.ObjectRemovedAll),
+	}
+
+	// The client reconnects on its own after an interrupted stream, only a
+	// failed request closes the channel. Every established connection is
+	// therefore reported by the transport with the server time, so that
+	// objects modified since the previous one can be resumed.
+	connects := make(chan time.Time, 1)
+	listenCtx := context.WithValue(ctx, watchConnectKey{}, func(serverTime time.Time) {
+		select {
+		case connects <- serverTime:
+		default:
+			// a resume is pending already
+		}
+	})
+
+	delay := watchOpts.RetryMin
+	// server time up to which changes were reported, zero until connected
+	var since time.Time
+	connected := func(serverTime time.Time) {
+		if watchOpts.Resume && !since.IsZero() {
+			dsm.resumeWatch(ctx, bucketName, watchOpts, since, handler)
+		}
+		if serverTime.After(since) {
+			since = serverTime
+		}
+		delay = watchOpts.RetryMin
+	}
+	for attempt := 0; ; attempt++ {
+		infoCh := dsm.minioClient.ListenBucketNotification(listenCtx, bucketName, watchOpts.Prefix, watchOpts.Suffix, events)
+	listen:
+		for {
+			select {
+			case serverTime := <-connects:
+				connected(serverTime)
+			case info, ok := <-infoCh:
+				if !ok {
+					break listen
+				}
+				// a connection is reported before its first event, resume
+				// from before the events of the new connection
+				select {
+				case serverTime := <-connects:
+					connected(serverTime)
+				default:
+				}
+				if info.Err != nil {
+					dsm.logger().Warn("bucket notifications interrupted", "bucket", bucketName, "error", info.Err)
+					continue
+				}
+				for _, record := range info.Records {
+					event, ok := newObjectEvent(record)
+					if !ok {
+						continue
+					}
+					if event.Time.After(since) {
+						since = event.Time
+					}
+					handler(event)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > watchOpts.RetryMax {
+			delay = watchOpts.RetryMax
+		}
+		dsm.logger().Debug("reconnecting bucket notifications", "bucket", bucketName, "attempt", attempt+1)
+	}
+}
+
+// watchConnectKey is the context key of the function called by
+// watchTransport whenever Watch established a connection.
+type watchConnectKey struct{}
+
+// watchTransport reports the connections of Watch with the server time taken
+// from the Date header, the local clock may differ from the one of the
+// object modification times.
+type watchTransport struct {
+	base http.RoundTripper
+}
+
+func (t *watchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	connected, ok := req.Context().Value(watchConnectKey{}).(func(time.Time))
+	resp, err := t.base.RoundTrip(req)
+	if !ok || err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	serverTime, dateErr := http.ParseTime(resp.Header.Get("Date"))
+	if dateErr != nil {
+		serverTime = time.Now()
+	}
+	// the Date header has a resolution of seconds
+	connected(serverTime.Truncate(time.Second))
+	return resp, nil
+}
+
+// resumeWatch reports the objects modified since the given time as created.
+func (dsm *dataStoreMinio) resumeWatch(ctx context.Context, bucketName string, watchOpts WatchOptions, since time.Time, handler func(ObjectEvent)) {
+	objectCh := dsm.minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    watchOpts.Prefix,
+		Recursive: true,
+	})
+	for object := range objectCh {
+		if object.Err != nil {
+			dsm.logger().Warn("failed to resume bucket notifications", "bucket", bucketName, "error", object.Err)
+			return
+		}
+		if object.LastModified.Before(since) || !strings.HasSuffix(object.Key, watchOpts.Suffix) {
+			continue
+		}
+		handler(ObjectEvent{
+			Type:       OBJECT_EVENT_CREATED,
+			Name:       string(notification.ObjectCreatedAll),
+			BucketName: bucketName,
+			ObjectName: object.Key,
+			VersionID:  object.VersionID,
+			ETag:       strings.Trim(object.ETag, `"`),
+			Size:       object.Size,
+			Time:       object.LastModified,
+			Resumed:    true,
+		})
+	}
+}
+
+// newObjectEvent converts a notification record, ok is false for records
+// other than created or removed objects.
+func newObjectEvent(record notification.Event) (ObjectEvent, bool) {
+	event := ObjectEvent{
+		Name:       record.EventName,
+		BucketName: record.S3.Bucket.Name,
+		VersionID:  record.S3.Object.VersionID,
+		ETag:       strings.Trim(record.S3.Object.ETag, `"`),
+		Size:       record.S3.Object.Size,
+	}
+	switch {
+	case strings.HasPrefix(record.EventName, "s3:ObjectCreated:"):
+		event.Type = OBJECT_EVENT_CREATED
+	case strings.HasPrefix(record.EventName, "s3:ObjectRemoved:"):
+		event.Type = OBJECT_EVENT_REMOVED
+	default:
+		return event, false
+	}
+	// keys are URL encoded in notifications
+	event.ObjectName = record.S3.Object.Key
+	if key, err := url.QueryUnescape(record.S3.Object.Key); err == nil {
+		event.ObjectName = key
+	}
+	if t, err := time.Parse(time.RFC3339Nano, record.EventTime); err == nil {
+		event.Time = t
+	}
+	return event, true
+}
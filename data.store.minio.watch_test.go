@@ -0,0 +1,160 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	store "github.com/gradientzero/comby-store-minio"
+	"github.com/gradientzero/comby/v2"
+)
+
+func TestDataStoreWatch(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// create bucket
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("watch-bucket"),
+		comby.DataStoreSetOptionWithObjectName("other/init"),
+		comby.DataStoreSetOptionWithData([]byte("init")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// watch the uploads prefix
+	watchCtx, cancel := context.WithCancel(ctx)
+	eventCh := make(chan store.ObjectEvent, 10)
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- dataStore.Watch(watchCtx, "watch-bucket", func(event store.ObjectEvent) {
+			eventCh <- event
+		}, store.WatchOptionWithPrefix("uploads/"))
+	}()
+	time.Sleep(500 * time.Millisecond)
+
+	// created and removed objects are reported
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("watch-bucket"),
+		comby.DataStoreSetOptionWithObjectName("uploads/a b"),
+		comby.DataStoreSetOptionWithData([]byte("abc")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Delete(ctx,
+		comby.DataStoreDeleteOptionWithBucketName("watch-bucket"),
+		comby.DataStoreDeleteOptionWithObjectName("uploads/a b"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	for _, wantType := range []string{store.OBJECT_EVENT_CREATED, store.OBJECT_EVENT_REMOVED} {
+		select {
+		case event := <-eventCh:
+			if event.Type != wantType || event.BucketName != "watch-bucket" || event.ObjectName != "uploads/a b" {
+				t.Fatalf("wrong event: %+v", event)
+			}
+			if wantType == store.OBJECT_EVENT_CREATED && (event.Size != 3 || event.ETag == "") {
+				t.Fatalf("wrong event: %+v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("missing %s event", wantType)
+		}
+	}
+
+	// stop watching
+	cancel()
+	if err := <-doneCh; err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataStoreWatchResume(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	fake, endpoint := newFakeS3(t)
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio(endpoint, false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = dataStore.Watch(ctx, "watch-bucket", func(store.ObjectEvent) {}, store.WatchOptionWithRetry(0, time.Second)); err == nil {
+		t.Fatal("expected error")
+	}
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("watch-bucket"),
+		comby.DataStoreSetOptionWithObjectName("uploads/old"),
+		comby.DataStoreSetOptionWithData([]byte("old")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	// modification times are compared to the server time of the connection
+	time.Sleep(time.Second)
+
+	// watch with resume
+	watchCtx, cancel := context.WithCancel(ctx)
+	eventCh := make(chan store.ObjectEvent, 10)
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- dataStore.Watch(watchCtx, "watch-bucket", func(event store.ObjectEvent) {
+			eventCh <- event
+		}, store.WatchOptionWithPrefix("uploads/"), store.WatchOptionWithResume(true))
+	}()
+	for fake.listens.Load() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the server ends the stream, the client reconnects on its own and the
+	// object written in between is resumed, the older one is not
+	if err = dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("watch-bucket"),
+		comby.DataStoreSetOptionWithObjectName("uploads/new"),
+		comby.DataStoreSetOptionWithData([]byte("new")),
+	); err != nil {
+		t.Fatal(err)
+	}
+	fake.hangup <- struct{}{}
+	select {
+	case event := <-eventCh:
+		if !event.Resumed || event.Type != store.OBJECT_EVENT_CREATED || event.ObjectName != "uploads/new" {
+			t.Fatalf("wrong event: %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("missing resumed event")
+	}
+	if fake.listens.Load() != 2 {
+		t.Fatalf("expected 2 connections, got %d", fake.listens.Load())
+	}
+
+	// stop watching
+	cancel()
+	if err := <-doneCh; err != context.Canceled {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case event := <-eventCh:
+		t.Fatalf("unexpected event: %+v", event)
+	default:
+	}
+}
@@ -2,10 +2,14 @@ package store_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	store "github.com/gradientzero/comby-store-minio"
 	"github.com/gradientzero/comby/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 func TestDataStore1(t *testing.T) {
@@ -321,3 +325,138 @@ func TestDataStoreInfo(t *testing.T) {
 		t.Fatalf("failed to close connection: %v", err)
 	}
 }
+
+func TestDataStoreCopyConditional(t *testing.T) {
+	var err error
+	ctx := context.Background()
+
+	// setup and init store
+	dataStore := store.NewDataStoreMinio("127.0.0.1:9000", false, "ROOTNAME", "CHANGEME123")
+	if err = dataStore.Init(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set source value
+	if err := dataStore.Set(ctx,
+		comby.DataStoreSetOptionWithBucketName("source-bucket"),
+		comby.DataStoreSetOptionWithObjectName("source-object"),
+		comby.DataStoreSetOptionWithContentType("text/plain"),
+		comby.DataStoreSetOptionWithData([]byte("data to copy")),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy with a non-matching ETag is rejected
+	if err := dataStore.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("source-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("source-object"),
+		comby.DataStoreCopyOptionWithDstBucketName("dest-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("dest-object"),
+		store.DataStoreCopyOptionWithMatchETag("does-not-match"),
+	); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got: %v", err)
+	}
+
+	// Copy with the ETag of the source as none-match ETag is rejected
+	srcStat, err := dataStore.Stat(ctx,
+		comby.DataStoreGetOptionWithBucketName("source-bucket"),
+		comby.DataStoreGetOptionWithObjectName("source-object"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataStore.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("source-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("source-object"),
+		comby.DataStoreCopyOptionWithDstBucketName("dest-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("dest-object"),
+		store.DataStoreCopyOptionWithNoneMatchETag(srcStat.ETag),
+	); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got: %v", err)
+	}
+
+	// Copy of a source not modified since is rejected
+	if err := dataStore.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("source-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("source-object"),
+		comby.DataStoreCopyOptionWithDstBucketName("dest-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("dest-object"),
+		store.DataStoreCopyOptionWithModifiedSince(time.Now().Add(time.Hour)),
+	); !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Fatalf("expected precondition error, got: %v", err)
+	}
+
+	// Copy with replaced metadata and tags
+	if err := dataStore.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("source-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("source-object"),
+		comby.DataStoreCopyOptionWithDstBucketName("dest-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("dest-object"),
+		store.DataStoreCopyOptionWithMetadataDirective(store.METADATA_DIRECTIVE_REPLACE),
+		store.DataStoreCopyOptionWithContentType("application/json"),
+		store.DataStoreCopyOptionWithUserMetadata(map[string]string{"origin": "copy"}),
+		store.DataStoreCopyOptionWithTags(map[string]string{"kind": "copy"}),
+		store.DataStoreCopyOptionWithMatchETag(srcStat.ETag),
+		store.DataStoreCopyOptionWithNoneMatchETag("does-not-match"),
+		store.DataStoreCopyOptionWithUnmodifiedSince(time.Now().Add(time.Hour)),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Copy without directive keeps metadata and tags of its source
+	if err := dataStore.Copy(ctx,
+		comby.DataStoreCopyOptionWithSrcBucketName("dest-bucket"),
+		comby.DataStoreCopyOptionWithSrcObjectName("dest-object"),
+		comby.DataStoreCopyOptionWithDstBucketName("dest-bucket"),
+		comby.DataStoreCopyOptionWithDstObjectName("kept-object"),
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// both copies have the replaced content type, metadata and tags
+	minioClient, err := minio.New("127.0.0.1:9000", &minio.Options{
+		Creds: credentials.NewStaticV4("ROOTNAME", "CHANGEME123", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, objectName := range []string{"dest-object", "kept-object"} {
+		stat, err := dataStore.Stat(ctx,
+			comby.DataStoreGetOptionWithBucketName("dest-bucket"),
+			comby.DataStoreGetOptionWithObjectName(objectName),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.ContentType != "application/json" || stat.UserMetadata["Origin"] != "copy" {
+			t.Fatalf("wrong metadata of %s: %s, %v", objectName, stat.ContentType, stat.UserMetadata)
+		}
+		tags, err := minioClient.GetObjectTagging(ctx, "dest-bucket", objectName, minio.GetObjectTaggingOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tagMap := tags.ToMap(); len(tagMap) != 1 || tagMap["kind"] != "copy" {
+			t.Fatalf("wrong tags of %s: %v", objectName, tagMap)
+		}
+	}
+
+	// check totals
+	if dataStore.Total(ctx) != 3 {
+		t.Fatalf("wrong total after copy: %d", dataStore.Total(ctx))
+	}
+
+	// reset database
+	if err := dataStore.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// close connection
+	if err := dataStore.Close(ctx); err != nil {
+		t.Fatalf("failed to close connection: %v", err)
+	}
+}